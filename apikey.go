@@ -0,0 +1,144 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Where an API key is sent in requests to the ReST service.
+type APIKeyLocation string
+
+const (
+	// Send the API key in a request header.
+	APIKeyHeader APIKeyLocation = "header"
+	// Send the API key as a query string parameter.
+	APIKeyQuery APIKeyLocation = "query"
+)
+
+// Text that replaces an API key in errors.
+const redacted = "REDACTED"
+
+// An APIKeyAuthenticator authenticates requests with an API key in a header or query string parameter.
+type APIKeyAuthenticator struct {
+	name     string
+	value    string
+	location APIKeyLocation
+	// Matches the API key where it is sent, so it can be redacted without changing other text
+	sent *regexp.Regexp
+}
+
+// Create a new APIKeyAuthenticator that sends the API key value with the header or query string parameter name given.
+func NewAPIKeyAuthenticator(name, value string, location APIKeyLocation) (*APIKeyAuthenticator, error) {
+	if name == "" {
+		return nil, errors.New("API key name not defined")
+	}
+	if location != APIKeyHeader && location != APIKeyQuery {
+		return nil, fmt.Errorf("API key location %q is neither %s nor %s", location, APIKeyHeader, APIKeyQuery)
+	}
+	var sent string
+	if location == APIKeyQuery {
+		sent = `(^|[?&;\s"'])(` + regexp.QuoteMeta(url.QueryEscape(name)) + `=)(?:` + regexp.QuoteMeta(url.QueryEscape(value)) + `|` + regexp.QuoteMeta(value) + `)([&;#\s"']|$)`
+	} else {
+		sent = `(?i)(^|[^\w-])(` + regexp.QuoteMeta(name) + `:\s*\[?)` + regexp.QuoteMeta(value) + `([\]\s",;]|$)`
+	}
+	return &APIKeyAuthenticator{
+		name:     name,
+		value:    value,
+		location: location,
+		sent:     regexp.MustCompile(sent),
+	}, nil
+}
+
+// Authenticate adds the API key to the request.
+// A query string parameter replaces any of the same name in the Operation's query data, other parameters are left as they are.
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	if a.location == APIKeyHeader {
+		req.Header.Set(a.name, a.value)
+		return nil
+	}
+	params := []string{url.QueryEscape(a.name) + "=" + url.QueryEscape(a.value)}
+	if req.URL.RawQuery != "" {
+		var kept []string
+		for _, p := range strings.Split(req.URL.RawQuery, "&") {
+			k, err := url.QueryUnescape(strings.SplitN(p, "=", 2)[0])
+			if err != nil {
+				return fmt.Errorf("Query data could not be parsed to add the API key; %v", err)
+			}
+			if k != a.name {
+				kept = append(kept, p)
+			}
+		}
+		params = append(kept, params...)
+	}
+	req.URL.RawQuery = strings.Join(params, "&")
+	return nil
+}
+
+// redact replaces the API key where it appears as the query string parameter or header in the text given.
+func (a *APIKeyAuthenticator) redact(s string) string {
+	if a.value == "" {
+		return s
+	}
+	return a.sent.ReplaceAllString(s, "${1}${2}"+redacted+"${3}")
+}
+
+// redactRequest replaces the API key in the URL and headers of a request that has been sent.
+func (a *APIKeyAuthenticator) redactRequest(req *http.Request) {
+	if a.location == APIKeyHeader {
+		if req.Header.Get(a.name) == a.value {
+			req.Header = req.Header.Clone()
+			req.Header.Set(a.name, redacted)
+		}
+		return
+	}
+	u := *req.URL
+	u.RawQuery = a.redact(u.RawQuery)
+	req.URL = &u
+}
+
+// String describes the APIKeyAuthenticator without revealing the key.
+func (a *APIKeyAuthenticator) String() string {
+	return fmt.Sprintf("API key %s in %s: %s", a.name, a.location, redacted)
+}
+
+// Authenticate requests sent using this config with an API key, sent in the header or query string parameter name given.
+// The key is redacted from the errors returned when sending requests.
+func (c *Config) WithAPIKey(name, value string, location APIKeyLocation) *Config {
+	a, err := NewAPIKeyAuthenticator(name, value, location)
+	if err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
+		return c
+	}
+	return c.WithAuthenticator(a)
+}
+
+// redactor is implemented by Authenticators whose credentials may appear in errors, such as in the URL of a failed request,
+// or in the request recorded on a response.
+type redactor interface {
+	redact(s string) string
+	redactRequest(req *http.Request)
+}
+
+// redactError removes any credentials of the Authenticator from the error.
+func redactError(a Authenticator, err error) error {
+	r, ok := a.(redactor)
+	if !ok || err == nil {
+		return err
+	}
+	if ue, ok := err.(*url.Error); ok {
+		c := *ue
+		c.URL = r.redact(ue.URL)
+		c.Err = redactError(a, ue.Err)
+		return &c
+	}
+	if s := err.Error(); r.redact(s) != s {
+		return errors.New(r.redact(s))
+	}
+	return err
+}
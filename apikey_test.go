@@ -0,0 +1,123 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	var tests = []struct {
+		location APIKeyLocation
+		query    string
+		expected string
+	}{
+		{APIKeyQuery, "", "api_key=k%2Fy"},
+		{APIKeyQuery, "a=1&b=2", "a=1&b=2&api_key=k%2Fy"},
+		{APIKeyQuery, "b=2&api_key=old&a=1", "b=2&a=1&api_key=k%2Fy"},
+		{APIKeyQuery, "api_key=old", "api_key=k%2Fy"},
+		{APIKeyQuery, "z=1&filter=a%3Db+c&api%5Fkey=old&y=%7E&api_key=old2", "z=1&filter=a%3Db+c&y=%7E&api_key=k%2Fy"},
+		{APIKeyQuery, "z=1&y=a%20b&x", "z=1&y=a%20b&x&api_key=k%2Fy"},
+		{APIKeyHeader, "a=1", "a=1"},
+	}
+	for _, test := range tests {
+		a, err := NewAPIKeyAuthenticator("api_key", "k/y", test.location)
+		if err != nil {
+			t.Fatalf("Error creating authenticator: %v", err)
+		}
+		req, _ := http.NewRequest("GET", "http://test/path?"+test.query, nil)
+		a.Authenticate(context.Background(), req)
+		assert.Equal(t, test.expected, req.URL.RawQuery, "Query string not as expected for %q", test.query)
+		if test.location == APIKeyHeader {
+			assert.Equal(t, "k/y", req.Header.Get("api_key"))
+		}
+	}
+
+	_, err := NewAPIKeyAuthenticator("api_key", "key", "cookie")
+	assert.NotNil(t, err, "Expected an error for an unknown location")
+	assert.NotNil(t, NewConfig().WithEndPoint("http://test").WithAPIKey("", "key", APIKeyHeader).Validate(), "Expected an invalid config without a key name")
+	a, _ := NewAPIKeyAuthenticator("X-API-Key", "s3cret", APIKeyHeader)
+	assert.NotContains(t, fmt.Sprint(a), "s3cret", "API key should not be printed")
+}
+
+func TestAPIKeyAuthenticator_Redact(t *testing.T) {
+	var tests = []struct {
+		location APIKeyLocation
+		text     string
+		expected string
+	}{
+		{APIKeyQuery, "Get http://test/p?api_key=a&b=a: refused", "Get http://test/p?api_key=REDACTED&b=a: refused"},
+		{APIKeyQuery, `"http://test/p?x=1&api_key=a"`, `"http://test/p?x=1&api_key=REDACTED"`},
+		{APIKeyQuery, "a table of data", "a table of data"},
+		{APIKeyQuery, "my_api_key=a&api_key=ab", "my_api_key=a&api_key=ab"},
+		{APIKeyHeader, "X-Api-Key: a\r\nAccept: a", "X-Api-Key: REDACTED\r\nAccept: a"},
+		{APIKeyHeader, "map[Accept:[a] X-Api-Key:[a]]", "map[Accept:[a] X-Api-Key:[REDACTED]]"},
+		{APIKeyHeader, "a table of data", "a table of data"},
+	}
+	for _, test := range tests {
+		a, _ := NewAPIKeyAuthenticator("X-API-Key", "a", test.location)
+		if test.location == APIKeyQuery {
+			a, _ = NewAPIKeyAuthenticator("api_key", "a", test.location)
+		}
+		assert.Equal(t, test.expected, a.redact(test.text), "Redacted text not as expected for %q", test.text)
+	}
+}
+
+func TestSend_APIKey(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "s3cret" && r.URL.Query().Get("api_key") != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"query": %q}`, r.URL.RawQuery)
+	}))
+	defer s.Close()
+	var d struct {
+		Query string `json:"query"`
+	}
+
+	c := NewConfig().WithEndPoint(s.URL).WithAPIKey("X-API-Key", "s3cret", APIKeyHeader)
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	code, _ := Send(r)
+	assert.Equal(t, http.StatusOK, *code, "API key header not sent")
+
+	c = NewConfig().WithEndPoint(s.URL).WithAPIKey("api_key", "s3cret", APIKeyQuery)
+	r, _ = BuildRequest(c, NewGetOperation().WithQueryDataURLValues(url.Values{"q": {"x y"}}).WithResponseTarget(&d))
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusOK, *code, "API key query parameter not sent")
+	assert.Equal(t, "q=x+y&api_key=s3cret", d.Query, "API key not merged with the query data")
+	assert.Equal(t, "q=x+y", r.HTTPRequest.URL.RawQuery, "API key should not be added to the Request's HTTP request")
+
+	// The key is redacted from errors
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "not json %s", r.URL.RawQuery)
+	})
+	_, err := Send(r)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "s3cret", "API key not redacted from the decode error")
+	s.Close()
+	_, err = Send(r)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "api_key="+redacted), "API key not redacted from the URL in the error: %v", err)
+	assert.NotContains(t, err.Error(), "s3cret")
+}
+
+func TestSend_APIKey_RedactResponseRequest(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithAPIKey("api_key", "s3cret", APIKeyQuery)
+	r, _ := BuildRequest(c, NewGetOperation().WithQueryDataString("q=1"))
+	Send(r)
+	assert.Equal(t, "q=1&api_key="+redacted, r.HTTPResponse.Request.URL.RawQuery, "API key not redacted from the response's request URL")
+
+	c = NewConfig().WithEndPoint(s.URL).WithAPIKey("X-API-Key", "s3cret", APIKeyHeader)
+	r, _ = BuildRequest(c, NewGetOperation())
+	Send(r)
+	assert.Equal(t, redacted, r.HTTPResponse.Request.Header.Get("X-API-Key"), "API key not redacted from the response's request header")
+}
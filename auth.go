@@ -0,0 +1,272 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// An Authenticator adds credentials to the HTTP requests sent to the ReST service.
+// Authenticate is called each time a request is sent, including each copy of a hedged request,
+// so it can obtain or refresh credentials as needed. It must be safe for concurrent use.
+//
+// An Authenticator may also implement ChallengeHandler to respond to authentication challenges from the ReST service,
+// and ResponseAuthenticator to authenticate the ReST service from its responses.
+type Authenticator interface {
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// A ChallengeHandler is an Authenticator that can handle a 401 Unauthorized or 407 Proxy Authentication Required response.
+// HandleChallenge is given the response and returns true if the request should be authenticated and sent again.
+// A request is only sent again once.
+type ChallengeHandler interface {
+	HandleChallenge(resp *http.Response) (retry bool)
+}
+
+// A ResponseAuthenticator is an Authenticator that also authenticates the ReST service from its responses, as in Kerberos mutual authentication.
+// AuthenticateResponse is called with each response received, and if it returns an error the response is rejected.
+type ResponseAuthenticator interface {
+	AuthenticateResponse(resp *http.Response) error
+}
+
+// An abandoner is a ResponseAuthenticator that holds state for each request it authenticates until the response is authenticated.
+// abandon is called instead of AuthenticateResponse for a request that fails without a response being authenticated.
+type abandoner interface {
+	abandon(req *http.Request)
+}
+
+// A BearerTokenSource provides the bearer token to authenticate a request with.
+// It is called each time a request is sent so can fetch or refresh tokens as needed.
+type BearerTokenSource func(ctx context.Context) (string, error)
+
+// A BasicAuthenticator authenticates requests using HTTP basic authentication.
+type BasicAuthenticator struct {
+	UserId   string
+	Password string
+}
+
+// Create a new BasicAuthenticator for the user ID and password given.
+func NewBasicAuthenticator(userId, password string) *BasicAuthenticator {
+	return &BasicAuthenticator{
+		UserId:   userId,
+		Password: password,
+	}
+}
+
+// Authenticate sets the basic authentication header on the request.
+func (a *BasicAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	req.SetBasicAuth(a.UserId, a.Password)
+	return nil
+}
+
+// A BearerAuthenticator authenticates requests with a bearer token obtained from a BearerTokenSource.
+type BearerAuthenticator struct {
+	source BearerTokenSource
+	// Whether the source always provides the same token
+	static bool
+}
+
+// Create a new BearerAuthenticator that gets its tokens from the source given.
+func NewBearerAuthenticator(s BearerTokenSource) *BearerAuthenticator {
+	return &BearerAuthenticator{source: s}
+}
+
+// Authenticate gets a token from the source and sets the bearer authorization header on the request.
+func (a *BearerAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	t, err := a.source(ctx)
+	if err != nil {
+		return fmt.Errorf("Bearer token could not be obtained; %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+t)
+	return nil
+}
+
+// Authenticate requests sent using this config with the Authenticator given.
+// This allows authentication schemes other than those built in to be used.
+func (c *Config) WithAuthenticator(a Authenticator) *Config {
+	c.auth = a
+	return c
+}
+
+// authenticator returns the Authenticator for the credentials defined on the config, or nil if there are none.
+func (c *Config) authenticator() Authenticator {
+	switch {
+	case c.auth != nil:
+		return c.auth
+	case c.UserId != nil:
+		var password string
+		if c.Password != nil {
+			password = *c.Password
+		}
+		return NewBasicAuthenticator(*c.UserId, password)
+	case c.BearerToken != nil:
+		t := *c.BearerToken
+		a := NewBearerAuthenticator(func(ctx context.Context) (string, error) {
+			return t, nil
+		})
+		a.static = true
+		return a
+	case c.tokenSource != nil:
+		return NewBearerAuthenticator(c.tokenSource)
+	}
+	return nil
+}
+
+// authMethods returns the number of different ways of authenticating that have been defined on the config.
+func (c *Config) authMethods() (n int) {
+	if c.auth != nil {
+		n++
+	}
+	if c.UserId != nil || c.Password != nil {
+		n++
+	}
+	if c.BearerToken != nil || c.tokenSource != nil {
+		n++
+	}
+	return
+}
+
+// isChallenge returns whether the response is an authentication challenge.
+func isChallenge(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusProxyAuthRequired
+}
+
+// A challenge is an authentication scheme and its parameters from a WWW-Authenticate header.
+type challenge struct {
+	scheme string
+	// A token68 value, used by schemes such as Negotiate instead of parameters
+	token  string
+	params map[string]string
+}
+
+// parseChallenges parses the challenges in WWW-Authenticate header values, as defined in RFC 7235 section 4.1.
+// Parameter names are converted to lower case.
+func parseChallenges(values []string) (cs []challenge) {
+	for _, v := range values {
+		p := &authParser{s: v}
+		for {
+			p.skip(" \t,")
+			scheme := p.token()
+			if scheme == "" {
+				break
+			}
+			c := challenge{scheme: scheme, params: make(map[string]string)}
+			p.skip(" \t")
+			if t := p.token68(); t != "" {
+				c.token = t
+				cs = append(cs, c)
+				continue
+			}
+			for {
+				start := p.i
+				p.skip(" \t")
+				name := p.token()
+				p.skip(" \t")
+				if name == "" || !p.consume('=') {
+					// The start of the next challenge
+					p.i = start
+					break
+				}
+				p.skip(" \t")
+				c.params[strings.ToLower(name)] = p.value()
+				p.skip(" \t")
+				if !p.consume(',') {
+					break
+				}
+			}
+			cs = append(cs, c)
+		}
+	}
+	return
+}
+
+// authParams returns the parameters of an Authorization header value for the scheme given.
+func authParams(header, scheme string) (map[string]string, bool) {
+	cs := parseChallenges([]string{header})
+	if len(cs) == 0 || !strings.EqualFold(cs[0].scheme, scheme) {
+		return nil, false
+	}
+	return cs[0].params, true
+}
+
+// An authParser scans the authentication parameters of a header value.
+type authParser struct {
+	s string
+	i int
+}
+
+func (p *authParser) atEnd() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *authParser) peek() string {
+	if p.atEnd() {
+		return ""
+	}
+	return p.s[p.i : p.i+1]
+}
+
+func (p *authParser) consume(c byte) bool {
+	if !p.atEnd() && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *authParser) skip(chars string) {
+	for !p.atEnd() && strings.IndexByte(chars, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// token68 scans a token68 value if there is one, which is only followed by the end of the value or a comma.
+func (p *authParser) token68() string {
+	start := p.i
+	for !p.atEnd() && strings.IndexByte(" \t,=\"", p.s[p.i]) < 0 {
+		p.i++
+	}
+	for p.consume('=') {
+	}
+	end := p.i
+	p.skip(" \t")
+	if end == start || !(p.atEnd() || p.peek() == ",") {
+		p.i = start
+		return ""
+	}
+	return p.s[start:end]
+}
+
+// token scans a token.
+func (p *authParser) token() string {
+	start := p.i
+	for !p.atEnd() && strings.IndexByte(" \t,=\"", p.s[p.i]) < 0 {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// value scans a token or quoted string.
+func (p *authParser) value() string {
+	if !p.consume('"') {
+		return p.token()
+	}
+	var b strings.Builder
+	for !p.atEnd() {
+		c := p.s[p.i]
+		p.i++
+		switch c {
+		case '\\':
+			if !p.atEnd() {
+				b.WriteByte(p.s[p.i])
+				p.i++
+			}
+		case '"':
+			return b.String()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package restclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// challengeAuthenticator sends a header with the current challenge nonce, learning a new nonce when challenged.
+type challengeAuthenticator struct {
+	mu         sync.Mutex
+	nonce      string
+	challenges int
+}
+
+func (a *challengeAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	req.Header.Set("Authorization", "Nonce "+a.nonce)
+	return nil
+}
+
+func (a *challengeAuthenticator) HandleChallenge(resp *http.Response) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.challenges++
+	a.nonce = resp.Header.Get("WWW-Authenticate")
+	return a.nonce != ""
+}
+
+func TestConfig_WithAuthenticator(t *testing.T) {
+	a := &challengeAuthenticator{}
+	c := NewConfig().WithEndPoint("http://testurl").WithAuthenticator(a)
+	assert.Equal(t, a, c.authenticator(), "Authenticator not set")
+	assert.Nil(t, c.Validate())
+
+	c.WithUserId("user").WithPassword("password")
+	assert.NotNil(t, c.Validate(), "Authenticator and basic credentials should not both be allowed")
+}
+
+func TestConfig_Authenticator(t *testing.T) {
+	c := NewConfig().WithUserId("user")
+	assert.Equal(t, NewBasicAuthenticator("user", ""), c.authenticator(), "Basic authenticator expected with an empty password")
+	c = NewConfig().WithBearerToken("token")
+	assert.IsType(t, &BearerAuthenticator{}, c.authenticator())
+	assert.Nil(t, NewConfig().authenticator(), "No authenticator expected without credentials")
+}
+
+func TestBasicAuthenticator(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://test", nil)
+	NewBasicAuthenticator("user", "pa55word").Authenticate(context.Background(), req)
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pa55word")), req.Header.Get("Authorization"))
+}
+
+func TestSend_AuthenticatorChallenge(t *testing.T) {
+	var calls int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Nonce abc" {
+			w.Header().Set("WWW-Authenticate", "abc")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+
+	a := &challengeAuthenticator{}
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(a)
+	r, _ := BuildRequest(c, NewPostOperation().WithBodyDataString(`{"a": 1}`))
+	assert.Equal(t, "", r.HTTPRequest.Header.Get("Authorization"), "Authenticator should only be called when sending")
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code, "Request not sent again after the challenge")
+	assert.Equal(t, 2, calls)
+
+	// The learnt nonce is used straight away on the next request
+	Send(r)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 1, a.challenges)
+
+	// A challenge is only handled once per request
+	a.nonce = "wrong"
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("WWW-Authenticate", "other")
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusUnauthorized, *code)
+	assert.Equal(t, 5, calls, "Request should only be sent again once")
+}
+
+type failingAuthenticator struct{}
+
+func (failingAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	return errors.New("no credentials")
+}
+
+func TestSend_AuthenticatorError(t *testing.T) {
+	var calls int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer s.Close()
+	r, _ := BuildRequest(NewConfig().WithEndPoint(s.URL).WithAuthenticator(failingAuthenticator{}), NewGetOperation())
+	_, err := Send(r)
+	assert.NotNil(t, err, "Expected the error from the authenticator")
+	assert.Equal(t, 0, calls, "Request should not be sent if it cannot be authenticated")
+}
+
+func TestParseChallenges(t *testing.T) {
+	cs := parseChallenges([]string{
+		`Negotiate, Basic realm="a \"b\"", charset=UTF-8`,
+		`Newauth realm="apps", type=1, title="Login to \"apps\"", Negotiate YIIabc==, Bearer`,
+	})
+	if assert.Len(t, cs, 5) {
+		assert.Equal(t, "Negotiate", cs[0].scheme)
+		assert.Equal(t, "", cs[0].token)
+		assert.Equal(t, map[string]string{"realm": `a "b"`, "charset": "UTF-8"}, cs[1].params)
+		assert.Equal(t, "Newauth", cs[2].scheme)
+		assert.Equal(t, `Login to "apps"`, cs[2].params["title"])
+		assert.Equal(t, "1", cs[2].params["type"])
+		assert.Equal(t, "Negotiate", cs[3].scheme)
+		assert.Equal(t, "YIIabc==", cs[3].token)
+		assert.Equal(t, "Bearer", cs[4].scheme)
+	}
+}
@@ -0,0 +1,127 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"sync"
+)
+
+// A BatchMode defines how a Batch handles failed Operations.
+type BatchMode int
+
+const (
+	// Send every Operation in the Batch and return all of the errors.
+	BatchCollectAll BatchMode = iota
+	// Stop sending Operations after the first failure. Operations in flight are cancelled.
+	BatchFailFast
+)
+
+// Default number of Operations a Batch sends at the same time.
+const DefaultBatchConcurrency = 10
+
+// A BatchResult holds the outcome of one Operation of a Batch.
+// An Operation that was not sent because the Batch stopped early has a nil Request.
+type BatchResult struct {
+	Request    *Request
+	StatusCode int
+	Err        error
+}
+
+// A Batch sends many Operations against the same Config with bounded concurrency.
+// All Operations share the Config's HTTP client and rate limiter.
+// An Operation fails if it cannot be sent, its response cannot be decoded or the ReST service responds with a 4xx or 5xx status.
+type Batch struct {
+	config      *Config
+	operations  []*Operation
+	concurrency int
+	mode        BatchMode
+}
+
+// Create a new Batch that will send the Operations using the Config.
+func NewBatch(c *Config, ops []*Operation) *Batch {
+	return &Batch{
+		config:      c,
+		operations:  ops,
+		concurrency: DefaultBatchConcurrency,
+		mode:        BatchCollectAll,
+	}
+}
+
+// Set the maximum number of Operations sent at the same time.
+func (b *Batch) WithConcurrency(n int) *Batch {
+	if n < 1 {
+		n = 1
+	}
+	b.concurrency = n
+	return b
+}
+
+// Set how the Batch handles failed Operations.
+func (b *Batch) WithMode(m BatchMode) *Batch {
+	b.mode = m
+	return b
+}
+
+// Send the Operations of the Batch.
+// The results are in the same order as the Operations.
+// The error returned aggregates the errors of all failed Operations using go-multierror, and is nil if all succeeded.
+func (b *Batch) Execute(ctx context.Context) ([]BatchResult, error) {
+	results := make([]BatchResult, len(b.operations))
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	sem := make(chan struct{}, b.concurrency)
+	var wg sync.WaitGroup
+	for i, o := range b.operations {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, o *Operation) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = b.send(ctx, o)
+			if results[i].Err != nil && b.mode == BatchFailFast {
+				cancel()
+			}
+		}(i, o)
+	}
+	wg.Wait()
+
+	var errs *multierror.Error
+	for i, res := range results {
+		if res.Err == nil {
+			continue
+		}
+		if b.mode == BatchFailFast && parent.Err() == nil && errors.Is(res.Err, context.Canceled) {
+			// Cancelled by the Batch after another Operation failed rather than by the caller
+			continue
+		}
+		errs = multierror.Append(errs, fmt.Errorf("Operation %d: %v", i, res.Err))
+	}
+	return results, errs.ErrorOrNil()
+}
+
+func (b *Batch) send(ctx context.Context, o *Operation) (res BatchResult) {
+	res.Request, res.Err = BuildRequest(b.config, o)
+	if res.Err != nil {
+		return
+	}
+	// Keep the connection open for the next Operation rather than connecting for each one
+	res.Request.HTTPRequest.Close = false
+	code, err := SendContext(ctx, res.Request)
+	res.StatusCode = *code
+	res.Err = err
+	if err == nil && res.StatusCode >= 400 {
+		res.Err = fmt.Errorf("Received HTTP status %d from the ReST service", res.StatusCode)
+	}
+	return
+}
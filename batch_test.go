@@ -0,0 +1,143 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingLimiter struct {
+	count int32
+}
+
+func (l *countingLimiter) Wait(ctx context.Context) error {
+	atomic.AddInt32(&l.count, 1)
+	return ctx.Err()
+}
+
+// batchServer responds to /n with {"n": n} after a delay that is longer for lower n, and with 500 for paths in fail.
+func batchServer(fail map[int]bool, inFlight, maxInFlight *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cur := atomic.AddInt32(inFlight, 1)
+		defer atomic.AddInt32(inFlight, -1)
+		for {
+			m := atomic.LoadInt32(maxInFlight)
+			if cur <= m || atomic.CompareAndSwapInt32(maxInFlight, m, cur) {
+				break
+			}
+		}
+		n, _ := strconv.Atoi(r.URL.Path[1:])
+		time.Sleep(time.Millisecond * time.Duration(20-n))
+		if fail[n] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `{"n": %d}`, n)
+	}))
+}
+
+type batchData struct {
+	N int `json:"n"`
+}
+
+func TestBatch_Execute(t *testing.T) {
+	var inFlight, maxInFlight int32
+	s := batchServer(nil, &inFlight, &maxInFlight)
+	defer s.Close()
+
+	l := &countingLimiter{}
+	c := NewConfig().WithEndPoint(s.URL).WithRateLimiter(l)
+	data := make([]batchData, 20)
+	var ops []*Operation
+	for i := range data {
+		ops = append(ops, NewGetOperation().WithPath(strconv.Itoa(i)).WithResponseTarget(&data[i]))
+	}
+	results, err := NewBatch(c, ops).WithConcurrency(4).Execute(context.Background())
+	assert.Nil(t, err, "Batch returned an error")
+	assert.Len(t, results, 20, "Expected a result per operation")
+	for i, res := range results {
+		assert.Nil(t, res.Err, "Operation %d returned an error", i)
+		assert.Equal(t, http.StatusOK, res.StatusCode, "Operation %d status not as expected", i)
+		assert.Equal(t, ops[i], res.Request.Operation, "Result %d out of order", i)
+		assert.Equal(t, i, data[i].N, "Response %d not decoded into the target", i)
+	}
+	assert.True(t, maxInFlight <= 4, "Concurrency limit exceeded: %d", maxInFlight)
+	assert.Equal(t, int32(20), l.count, "Rate limiter not used for every operation")
+}
+
+func TestBatch_Errors(t *testing.T) {
+	var inFlight, maxInFlight int32
+	s := batchServer(map[int]bool{3: true, 5: true}, &inFlight, &maxInFlight)
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+	var ops []*Operation
+	for i := 0; i < 10; i++ {
+		ops = append(ops, NewGetOperation().WithPath(strconv.Itoa(i)))
+	}
+
+	results, err := NewBatch(c, ops).WithConcurrency(2).Execute(context.Background())
+	assert.NotNil(t, err, "Expected an error")
+	assert.IsType(t, &multierror.Error{}, err, "Expected errors to be aggregated")
+	assert.Len(t, err.(*multierror.Error).Errors, 2, "Expected both failures to be collected")
+	for i, res := range results {
+		if i == 3 || i == 5 {
+			assert.NotNil(t, res.Err, "Operation %d should have failed", i)
+			assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+		} else {
+			assert.Nil(t, res.Err, "Operation %d should not have failed", i)
+		}
+	}
+
+	results, err = NewBatch(c, ops).WithConcurrency(1).WithMode(BatchFailFast).Execute(context.Background())
+	assert.NotNil(t, err, "Expected an error")
+	assert.Len(t, err.(*multierror.Error).Errors, 1, "Expected only the first failure")
+	assert.NotNil(t, results[3].Err, "Operation 3 should have failed")
+	for i := 4; i < 10; i++ {
+		assert.Nil(t, results[i].Request, "Operation %d should not have been sent", i)
+		assert.NotNil(t, results[i].Err, "Operation %d should report it was not sent", i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewBatch(c, ops).Execute(ctx)
+	assert.NotNil(t, err, "Expected an error when the context is cancelled")
+	assert.Len(t, err.(*multierror.Error).Errors, 10, "Expected every operation to report cancellation")
+}
+
+func TestBatch_Concurrency(t *testing.T) {
+	b := NewBatch(NewConfig(), nil)
+	assert.Equal(t, DefaultBatchConcurrency, b.concurrency)
+	b.WithConcurrency(0)
+	assert.Equal(t, 1, b.concurrency, "Concurrency should be at least one")
+}
+
+func TestBatch_Connections(t *testing.T) {
+	var conns int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		fmt.Fprint(w, `{"n": 1}`)
+	}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.Start()
+	defer s.Close()
+
+	var ops []*Operation
+	for i := 0; i < 100; i++ {
+		ops = append(ops, NewGetOperation().WithResponseTarget(&batchData{}))
+	}
+	_, err := NewBatch(NewConfig().WithEndPoint(s.URL), ops).Execute(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, atomic.LoadInt32(&conns) <= DefaultBatchConcurrency, "Expected connections to be reused, %d opened", atomic.LoadInt32(&conns))
+}
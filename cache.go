@@ -0,0 +1,443 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	multierror "github.com/hashicorp/go-multierror"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Fraction of the time since the response was last modified that it is considered fresh when it has no explicit expiry.
+	cacheHeuristicFraction = 0.1
+	// Maximum heuristic freshness lifetime.
+	cacheHeuristicMax = time.Hour * 24
+	// Largest number of seconds taken from a header, larger values are reduced to this (RFC 7234 section 1.2.1).
+	maxDeltaSeconds = 1 << 31
+)
+
+// How long a stale response being served can be revalidated in the background for.
+var backgroundRevalidateTimeout = time.Minute
+
+// Status codes that can be cached without explicit freshness information (RFC 7231 section 6.1)
+var cacheHeuristicStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// A Cache is a private HTTP cache for GET requests, following RFC 7234.
+// It honours the Cache-Control, Expires, Vary, ETag and Last-Modified headers, revalidates stale responses with If-None-Match and If-Modified-Since,
+// and supports the stale-while-revalidate and stale-if-error extensions of RFC 5861.
+// Cached responses are held in a CacheStorage. A Cache can only be used by one Config, as responses are cached for its credentials.
+type Cache struct {
+	storage      CacheStorage
+	now          func() time.Time
+	mu           sync.Mutex
+	revalidating map[string]bool
+	config       *Config
+}
+
+// A cacheEntry is a response held in the cache.
+type cacheEntry struct {
+	StatusCode   int
+	Header       http.Header
+	Body         []byte
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// The values of the request headers named by the Vary response header
+	Vary http.Header
+}
+
+// Create a new Cache that holds responses in the storage given.
+func NewCache(s CacheStorage) *Cache {
+	return &Cache{
+		storage:      s,
+		now:          time.Now,
+		revalidating: make(map[string]bool),
+	}
+}
+
+// Cache the responses to GET requests sent using this config.
+func (c *Config) WithCache(cache *Cache) *Config {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.config != nil && cache.config != c {
+		c.configErr = multierror.Append(c.configErr, errors.New("Cache is already used by another config"))
+		return c
+	}
+	cache.config = c
+	c.cache = cache
+	return c
+}
+
+// roundTrip answers the Request from the cache if possible, otherwise sends it to the ReST service and caches the response.
+func (c *Cache) roundTrip(ctx context.Context, r *Request) error {
+	req := r.HTTPRequest
+	key := cacheKey(req)
+	if req.Method != "GET" {
+		err := r.sharedRoundTrip(ctx)
+		// A failed precondition or conflict means the cached response is out of date as well
+		if err == nil && (r.StatusCode < 400 || r.StatusCode == http.StatusPreconditionFailed || r.StatusCode == http.StatusConflict) {
+			c.invalidate(r)
+		}
+		return err
+	}
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-store"]; ok {
+		return r.sharedRoundTrip(ctx)
+	}
+	var e *cacheEntry
+	if req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		e = c.load(key, req)
+	}
+	if e == nil {
+		return c.fetch(ctx, r, key)
+	}
+
+	now := c.now()
+	respCC := parseCacheControl(e.Header)
+	age := e.age(now)
+	lifetime := e.freshnessLifetime(respCC)
+	_, reqNoCache := reqCC["no-cache"]
+	_, respNoCache := respCC["no-cache"]
+	_, mustRevalidate := respCC["must-revalidate"]
+	fresh := age < lifetime
+	if v, ok := cacheControlSeconds(reqCC, "max-age"); ok && age > v {
+		fresh = false
+	}
+	if v, ok := cacheControlSeconds(reqCC, "min-fresh"); ok && lifetime-age < v {
+		fresh = false
+	}
+	if fresh && !reqNoCache && !respNoCache {
+		c.serve(r, e, now)
+		return nil
+	}
+	staleness := age - lifetime
+	canServeStale := !reqNoCache && !respNoCache && !mustRevalidate
+	if v, ok := cacheControlSeconds(reqCC, "max-stale"); ok && canServeStale && staleness <= v {
+		c.serve(r, e, now)
+		return nil
+	}
+	if v, ok := cacheControlSeconds(respCC, "stale-while-revalidate"); ok && canServeStale && staleness <= v {
+		c.serve(r, e, now)
+		c.revalidateInBackground(r, key, e)
+		return nil
+	}
+	err := c.revalidate(ctx, r, key, e)
+	if err != nil || r.StatusCode >= http.StatusInternalServerError {
+		sie, ok := cacheControlSeconds(respCC, "stale-if-error")
+		if v, rok := cacheControlSeconds(reqCC, "stale-if-error"); rok {
+			sie, ok = v, true
+		}
+		if ok && canServeStale && staleness <= sie {
+			c.serve(r, e, now)
+			return nil
+		}
+	}
+	return err
+}
+
+// fetch sends the Request to the ReST service and stores the response if it can be cached.
+func (c *Cache) fetch(ctx context.Context, r *Request, key string) error {
+	requestTime := c.now()
+	err := r.sharedRoundTrip(ctx)
+	if err == nil {
+		c.store(key, r, requestTime)
+	}
+	return err
+}
+
+// revalidate sends a conditional request for the stale entry.
+// If the ReST service responds 304 Not Modified the entry is updated and served, otherwise the new response is used.
+func (c *Cache) revalidate(ctx context.Context, r *Request, key string, e *cacheEntry) error {
+	rv := &Request{
+		Config:      r.Config,
+		Operation:   r.Operation,
+		HTTPRequest: r.HTTPRequest.Clone(ctx),
+	}
+	if etag := e.Header.Get("ETag"); etag != "" {
+		rv.HTTPRequest.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		rv.HTTPRequest.Header.Set("If-Modified-Since", lm)
+	}
+	requestTime := c.now()
+	err := rv.sharedRoundTrip(ctx)
+	if err != nil {
+		return err
+	}
+	if rv.StatusCode == http.StatusNotModified {
+		for k, v := range rv.HTTPResponse.Header {
+			e.Header[k] = v
+		}
+		e.RequestTime = requestTime
+		e.ResponseTime = c.now()
+		c.save(key, e)
+		c.serve(r, e, e.ResponseTime)
+		return nil
+	}
+	r.setResult(attemptResult{response: rv.HTTPResponse, body: rv.ResponseBody})
+	r.HedgeAttempt = rv.HedgeAttempt
+	if r.StatusCode < http.StatusInternalServerError {
+		c.store(key, r, requestTime)
+	}
+	return nil
+}
+
+// revalidateInBackground revalidates the entry without blocking the Request, unless it is already being revalidated.
+func (c *Cache) revalidateInBackground(r *Request, key string, e *cacheEntry) {
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.mu.Unlock()
+	bg := &Request{
+		Config:      r.Config,
+		Operation:   r.Operation,
+		HTTPRequest: r.HTTPRequest,
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundRevalidateTimeout)
+		defer cancel()
+		c.revalidate(ctx, bg, key, e)
+		c.mu.Lock()
+		delete(c.revalidating, key)
+		c.mu.Unlock()
+	}()
+}
+
+// serve answers the Request with the cached entry.
+func (c *Cache) serve(r *Request, e *cacheEntry, now time.Time) {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+	r.setResult(attemptResult{
+		response: &http.Response{
+			Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+			StatusCode:    e.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        h,
+			Body:          http.NoBody,
+			ContentLength: int64(len(e.Body)),
+			Request:       r.HTTPRequest,
+		},
+		body: append([]byte(nil), e.Body...),
+	})
+	r.FromCache = true
+}
+
+// store saves the response of the Request if it can be cached, or removes any existing entry if it cannot.
+func (c *Cache) store(key string, r *Request, requestTime time.Time) {
+	resp := r.HTTPResponse
+	reqCC := parseCacheControl(r.HTTPRequest.Header)
+	respCC := parseCacheControl(resp.Header)
+	_, reqNoStore := reqCC["no-store"]
+	_, respNoStore := respCC["no-store"]
+	vary := headerTokens(resp.Header, "Vary")
+	if reqNoStore || respNoStore || resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified || contains(vary, "*") {
+		return
+	}
+	_, maxAge := respCC["max-age"]
+	explicit := maxAge || resp.Header.Get("Expires") != ""
+	if !explicit && !cacheHeuristicStatus[resp.StatusCode] {
+		return
+	}
+	if !explicit && resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		// Neither fresh nor able to be revalidated
+		return
+	}
+	e := &cacheEntry{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         r.ResponseBody,
+		RequestTime:  requestTime,
+		ResponseTime: c.now(),
+	}
+	if len(vary) > 0 {
+		e.Vary = make(http.Header)
+		for _, h := range vary {
+			e.Vary[http.CanonicalHeaderKey(h)] = r.HTTPRequest.Header.Values(h)
+		}
+	}
+	c.save(key, e)
+}
+
+func (c *Cache) save(key string, e *cacheEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	c.storage.Set(key, b)
+}
+
+// load returns the cached entry for the request, or nil if there is none or it was selected by different request headers.
+func (c *Cache) load(key string, req *http.Request) *cacheEntry {
+	b, ok := c.storage.Get(key)
+	if !ok {
+		return nil
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		c.storage.Delete(key)
+		return nil
+	}
+	for h, v := range e.Vary {
+		if strings.Join(req.Header.Values(h), ", ") != strings.Join(v, ", ") {
+			return nil
+		}
+	}
+	return &e
+}
+
+// invalidate removes the entries a request with an unsafe method may have changed (RFC 7234 section 4.4).
+func (c *Cache) invalidate(r *Request) {
+	c.storage.Delete(cacheKey(r.HTTPRequest))
+	for _, h := range []string{"Location", "Content-Location"} {
+		l := r.HTTPResponse.Header.Get(h)
+		if l == "" {
+			continue
+		}
+		u, err := r.HTTPRequest.URL.Parse(l)
+		if err != nil || u.Host != r.HTTPRequest.URL.Host {
+			continue
+		}
+		c.storage.Delete("GET " + u.String())
+	}
+}
+
+// age calculates the current age of the entry (RFC 7234 section 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil && e.ResponseTime.After(date) {
+		apparentAge = e.ResponseTime.Sub(date)
+	}
+	var ageValue time.Duration
+	if v, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && v > 0 {
+		ageValue = deltaSeconds(v)
+	}
+	correctedAgeValue := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	initialAge := apparentAge
+	if correctedAgeValue > initialAge {
+		initialAge = correctedAgeValue
+	}
+	return initialAge + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime calculates how long the entry is fresh for (RFC 7234 section 4.2.1).
+func (e *cacheEntry) freshnessLifetime(cc map[string]string) time.Duration {
+	if v, ok := cacheControlSeconds(cc, "max-age"); ok {
+		return v
+	}
+	date, dateErr := http.ParseTime(e.Header.Get("Date"))
+	if dateErr != nil {
+		date = e.ResponseTime
+	}
+	if exp := e.Header.Get("Expires"); exp != "" {
+		t, err := http.ParseTime(exp)
+		if err != nil || !t.After(date) {
+			return 0
+		}
+		return t.Sub(date)
+	}
+	if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && cacheHeuristicStatus[e.StatusCode] && date.After(lm) {
+		h := time.Duration(float64(date.Sub(lm)) * cacheHeuristicFraction)
+		if h > cacheHeuristicMax {
+			h = cacheHeuristicMax
+		}
+		return h
+	}
+	return 0
+}
+
+func cacheKey(req *http.Request) string {
+	return "GET " + req.URL.String()
+}
+
+// parseCacheControl parses the directives of the Cache-Control headers into a map of directive name to value.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			kv := strings.SplitN(d, "=", 2)
+			k := strings.ToLower(strings.TrimSpace(kv[0]))
+			if len(kv) == 2 {
+				cc[k] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			} else {
+				cc[k] = ""
+			}
+		}
+	}
+	return cc
+}
+
+// cacheControlSeconds returns the value of a delta-seconds Cache-Control directive as a duration.
+// max-stale without a value means any staleness is accepted.
+func cacheControlSeconds(cc map[string]string, directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	if v == "" && directive == "max-stale" {
+		return time.Duration(1<<63 - 1), true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil && errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(v, "-") {
+		// Values too large to parse are treated as the largest value
+		n, err = maxDeltaSeconds, nil
+	}
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return deltaSeconds(n), true
+}
+
+// deltaSeconds converts a number of seconds from a header to a duration.
+func deltaSeconds(n int64) time.Duration {
+	if n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second
+}
+
+// headerTokens returns the comma separated tokens of a header.
+func headerTokens(h http.Header, name string) []string {
+	var tokens []string
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func contains(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
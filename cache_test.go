@@ -0,0 +1,302 @@
+package restclient
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type cacheTestServer struct {
+	*httptest.Server
+	mu           sync.Mutex
+	calls        int
+	cacheControl string
+	status       int
+	ifNoneMatch  []string
+}
+
+// newCacheTestServer creates a server whose Date header is taken from the test clock.
+func newCacheTestServer(cacheControl string, clock *testClock) *cacheTestServer {
+	s := &cacheTestServer{cacheControl: cacheControl, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls++
+		s.ifNoneMatch = append(s.ifNoneMatch, r.Header.Get("If-None-Match"))
+		if r.Method != "GET" {
+			return
+		}
+		w.Header().Set("Date", clock.now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Cache-Control", s.cacheControl)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Vary", "Accept")
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, `{"call": %d, "accept": %q}`, s.calls, r.Header.Get("Accept"))
+	}))
+	return s
+}
+
+func (s *cacheTestServer) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+type cacheData struct {
+	Call   int    `json:"call"`
+	Accept string `json:"accept"`
+}
+
+// testClock is a clock for the cache that only moves when advanced.
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestCache(clock *testClock) *Cache {
+	cache := NewCache(NewMemoryCacheStorage(10))
+	cache.now = clock.now
+	return cache
+}
+
+func cachedGet(t *testing.T, c *Config, o *Operation) (*Request, cacheData) {
+	var d cacheData
+	r, err := BuildRequest(c, o.WithResponseTarget(&d))
+	if err != nil {
+		t.Fatalf("Error building request: %v", err)
+	}
+	_, err = Send(r)
+	assert.Nil(t, err, "Error sending request")
+	return r, d
+}
+
+func TestCache_FreshAndRevalidate(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	s := newCacheTestServer("max-age=60", clock)
+	defer s.Close()
+	cache := newTestCache(clock)
+	c := NewConfig().WithEndPoint(s.URL).WithCache(cache)
+
+	r, d := cachedGet(t, c, NewGetOperation())
+	assert.False(t, r.FromCache, "First response should not be from the cache")
+	assert.Equal(t, 1, d.Call)
+
+	clock.advance(time.Second * 30)
+	r, d = cachedGet(t, c, NewGetOperation())
+	assert.True(t, r.FromCache, "Fresh response should be served from the cache")
+	assert.Equal(t, 1, d.Call, "Cached response not decoded into target")
+	assert.Equal(t, 1, s.callCount(), "Fresh response should not call the service")
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, "30", r.HTTPResponse.Header.Get("Age"), "Age header not set on cached response")
+
+	clock.advance(time.Second * 31)
+	r, d = cachedGet(t, c, NewGetOperation())
+	assert.True(t, r.FromCache, "Revalidated response should be served from the cache")
+	assert.Equal(t, http.StatusOK, r.StatusCode, "Status of the cached response should be used")
+	assert.Equal(t, 1, d.Call)
+	assert.Equal(t, 2, s.callCount(), "Stale response should be revalidated")
+	assert.Equal(t, `"v1"`, s.ifNoneMatch[1], "Revalidation should be conditional")
+
+	// Revalidation refreshed the entry
+	r, _ = cachedGet(t, c, NewGetOperation())
+	assert.True(t, r.FromCache)
+	assert.Equal(t, 2, s.callCount())
+
+	// The request can insist on revalidation
+	r, _ = cachedGet(t, c, NewGetOperation().WithHeader("Cache-Control", "no-cache"))
+	assert.Equal(t, 3, s.callCount(), "Request no-cache should force revalidation")
+
+	// Vary
+	r, d = cachedGet(t, c, NewGetOperation().WithHeader("Accept", "text/plain"))
+	assert.False(t, r.FromCache, "Response varying on a different Accept header should not be used")
+	assert.Equal(t, "text/plain", d.Accept)
+
+	// Unsafe methods invalidate
+	o := NewPostOperation()
+	rp, _ := BuildRequest(c, o)
+	Send(rp)
+	r, _ = cachedGet(t, c, NewGetOperation().WithHeader("Accept", "text/plain"))
+	assert.False(t, r.FromCache, "POST should invalidate the cached response")
+}
+
+func TestCache_NoStore(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	s := newCacheTestServer("no-store, max-age=60", clock)
+	defer s.Close()
+	cache := newTestCache(clock)
+	c := NewConfig().WithEndPoint(s.URL).WithCache(cache)
+	cachedGet(t, c, NewGetOperation())
+	r, _ := cachedGet(t, c, NewGetOperation())
+	assert.False(t, r.FromCache, "no-store response should not be cached")
+	assert.Equal(t, 2, s.callCount())
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	s := newCacheTestServer("max-age=10, stale-while-revalidate=30", clock)
+	defer s.Close()
+	cache := newTestCache(clock)
+	c := NewConfig().WithEndPoint(s.URL).WithCache(cache)
+	cachedGet(t, c, NewGetOperation())
+
+	clock.advance(time.Second * 20)
+	r, _ := cachedGet(t, c, NewGetOperation())
+	assert.True(t, r.FromCache, "Stale response should be served while revalidating")
+	for i := 0; i < 100 && s.callCount() < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, 2, s.callCount(), "Response not revalidated in the background")
+
+	clock.advance(time.Second * 60)
+	r, _ = cachedGet(t, c, NewGetOperation())
+	for i := 0; i < 100 && s.callCount() < 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.True(t, r.FromCache, "Revalidated response should be served from the cache")
+	assert.Equal(t, 3, s.callCount(), "Response beyond the stale-while-revalidate window should be revalidated")
+}
+
+func TestCache_StaleWhileRevalidate_Timeout(t *testing.T) {
+	timeout := backgroundRevalidateTimeout
+	backgroundRevalidateTimeout = time.Millisecond * 50
+	defer func() { backgroundRevalidateTimeout = timeout }()
+	clock := &testClock{t: time.Now()}
+	s := newCacheTestServer("max-age=10, stale-while-revalidate=30", clock)
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL).WithCache(newTestCache(clock))
+	cachedGet(t, c, NewGetOperation())
+
+	release := make(chan struct{})
+	defer close(release)
+	aborted := make(chan struct{}, 1)
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+		case <-release:
+		}
+	})
+	clock.advance(time.Second * 20)
+	r, _ := cachedGet(t, c, NewGetOperation())
+	assert.True(t, r.FromCache, "Stale response should be served while revalidating")
+	select {
+	case <-aborted:
+	case <-time.After(time.Second * 5):
+		t.Error("Background revalidation not aborted after the timeout")
+	}
+}
+
+func TestCache_StaleIfError(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	s := newCacheTestServer("max-age=10, stale-if-error=30", clock)
+	defer s.Close()
+	cache := newTestCache(clock)
+	c := NewConfig().WithEndPoint(s.URL).WithCache(cache)
+	cachedGet(t, c, NewGetOperation())
+
+	s.mu.Lock()
+	s.status = http.StatusInternalServerError
+	s.mu.Unlock()
+	clock.advance(time.Second * 20)
+	r, d := cachedGet(t, c, NewGetOperation())
+	assert.True(t, r.FromCache, "Stale response should be served on error")
+	assert.Equal(t, http.StatusOK, r.StatusCode)
+	assert.Equal(t, 1, d.Call)
+
+	clock.advance(time.Second * 60)
+	var d2 cacheData
+	r, _ = BuildRequest(c, NewGetOperation().WithResponseTarget(&d2))
+	code, _ := Send(r)
+	assert.False(t, r.FromCache, "Response beyond the stale-if-error window should not be served")
+	assert.Equal(t, http.StatusInternalServerError, *code)
+
+	s.Close()
+	_, err := Send(r)
+	assert.NotNil(t, err, "Expected an error when the service is unavailable and the response is too stale")
+}
+
+func TestCache_Credentials(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, `{"accept": %q}`, r.Header.Get("Authorization"))
+	}))
+	defer s.Close()
+
+	// A Cache cannot be shared by configs with different credentials
+	cache := NewCache(NewMemoryCacheStorage(10))
+	a := NewConfig().WithEndPoint(s.URL).WithBearerToken("tokenA").WithCache(cache)
+	b := NewConfig().WithEndPoint(s.URL).WithBearerToken("tokenB").WithCache(cache)
+	assert.Nil(t, a.Validate())
+	assert.NotNil(t, b.Validate(), "Cache used by a second config did not create an error in the configuration")
+	assert.Nil(t, a.WithCache(cache).Validate(), "Cache can be set again on the same config")
+
+	b = NewConfig().WithEndPoint(s.URL).WithBearerToken("tokenB").WithCache(NewCache(NewMemoryCacheStorage(10)))
+	_, d := cachedGet(t, a, NewGetOperation())
+	assert.Equal(t, "Bearer tokenA", d.Accept)
+	r, d := cachedGet(t, b, NewGetOperation())
+	assert.False(t, r.FromCache, "Response for other credentials served from the cache")
+	assert.Equal(t, "Bearer tokenB", d.Accept)
+}
+
+func TestCacheEntry_FreshnessLifetime(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	date := now.UTC().Format(http.TimeFormat)
+	var tests = []struct {
+		header   http.Header
+		expected time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=120"}, "Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)}}, time.Minute * 2},
+		{http.Header{"Cache-Control": {"max-age=9223372036854775807"}}, time.Second * maxDeltaSeconds},
+		{http.Header{"Cache-Control": {"max-age=99999999999999999999"}}, time.Second * maxDeltaSeconds},
+		{http.Header{"Date": {date}, "Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)}}, time.Hour},
+		{http.Header{"Date": {date}, "Expires": {"0"}}, 0},
+		{http.Header{"Date": {date}, "Last-Modified": {now.Add(-time.Hour * 10).UTC().Format(http.TimeFormat)}}, time.Hour},
+		{http.Header{"Date": {date}, "Last-Modified": {now.Add(-time.Hour * 1000).UTC().Format(http.TimeFormat)}}, cacheHeuristicMax},
+		{http.Header{"Date": {date}}, 0},
+	}
+	for _, test := range tests {
+		e := &cacheEntry{StatusCode: http.StatusOK, Header: test.header, ResponseTime: now}
+		assert.Equal(t, test.expected, e.freshnessLifetime(parseCacheControl(test.header)), "Freshness lifetime not as expected for %v", test.header)
+	}
+}
+
+func TestCacheEntry_Age(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	e := &cacheEntry{
+		Header: http.Header{
+			"Date": {now.Add(-time.Second * 5).UTC().Format(http.TimeFormat)},
+			"Age":  {"3"},
+		},
+		RequestTime:  now.Add(-time.Second),
+		ResponseTime: now,
+	}
+	// Corrected age value of 3+1 is less than the apparent age of 5
+	assert.Equal(t, time.Second*15, e.age(now.Add(time.Second*10)))
+	e.Header.Set("Age", "30")
+	assert.Equal(t, time.Second*41, e.age(now.Add(time.Second*10)))
+	e.Header.Set("Age", "9223372036854775807")
+	assert.True(t, e.age(now) > time.Second*maxDeltaSeconds, "Large age value should not overflow")
+}
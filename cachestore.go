@@ -0,0 +1,240 @@
+package restclient
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A CacheStorage holds the responses of a Cache. Implementations must be safe for concurrent use.
+type CacheStorage interface {
+	// Get returns the value stored for the key and whether there was one.
+	Get(key string) ([]byte, bool)
+	// Set stores the value for the key, replacing any existing value.
+	Set(key string, value []byte)
+	// Delete removes any value stored for the key.
+	Delete(key string)
+}
+
+// MemoryCacheStorage holds cached responses in memory, evicting the least recently used once the maximum number of entries is reached.
+type MemoryCacheStorage struct {
+	maxEntries int
+	mu         sync.Mutex
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+// Create a new MemoryCacheStorage holding up to maxEntries responses. Zero means no limit.
+func NewMemoryCacheStorage(maxEntries int) *MemoryCacheStorage {
+	return &MemoryCacheStorage{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored for the key and marks it as recently used.
+func (s *MemoryCacheStorage) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*memoryCacheItem).value, true
+}
+
+// Set stores the value for the key, evicting the least recently used entry if the storage is full.
+func (s *MemoryCacheStorage) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.ll.MoveToFront(e)
+		e.Value.(*memoryCacheItem).value = value
+		return
+	}
+	s.entries[key] = s.ll.PushFront(&memoryCacheItem{key: key, value: value})
+	if s.maxEntries > 0 && s.ll.Len() > s.maxEntries {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// Delete removes any value stored for the key.
+func (s *MemoryCacheStorage) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.ll.Remove(e)
+		delete(s.entries, key)
+	}
+}
+
+// Len returns the number of entries held.
+func (s *MemoryCacheStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// Default maximum total size of the responses held by a DiskCacheStorage.
+const DefaultDiskCacheMaxSize = 100 << 20
+
+// DiskCacheStorage holds cached responses in files within a directory, evicting the least recently used once its size or entry limit is reached.
+// The limits are only enforced for the entries written by this DiskCacheStorage and those in the directory when it was created.
+type DiskCacheStorage struct {
+	dir        string
+	maxEntries int
+	maxSize    int64
+	mu         sync.Mutex
+	size       int64
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type diskCacheItem struct {
+	name string
+	size int64
+}
+
+// Create a new DiskCacheStorage holding responses in the directory given, which is created if it does not exist.
+// It holds up to DefaultDiskCacheMaxSize bytes of responses.
+func NewDiskCacheStorage(dir string) (*DiskCacheStorage, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Cache directory could not be created; %v", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Cache directory could not be read; %v", err)
+	}
+	s := &DiskCacheStorage{
+		dir:     dir,
+		maxSize: DefaultDiskCacheMaxSize,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	// Entries already in the directory are ordered by when they were written
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), "tmp-") {
+			continue
+		}
+		s.entries[f.Name()] = s.ll.PushBack(&diskCacheItem{name: f.Name(), size: f.Size()})
+		s.size += f.Size()
+	}
+	return s, nil
+}
+
+// Limit the number of entries held. Zero means no limit.
+func (s *DiskCacheStorage) WithMaxEntries(n int) *DiskCacheStorage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxEntries = n
+	s.evict()
+	return s
+}
+
+// Limit the total size in bytes of the responses held. Zero means no limit.
+func (s *DiskCacheStorage) WithMaxSize(n int64) *DiskCacheStorage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSize = n
+	s.evict()
+	return s
+}
+
+// Get returns the value stored for the key and marks it as recently used.
+func (s *DiskCacheStorage) Get(key string) ([]byte, bool) {
+	name := s.name(key)
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	if e, ok := s.entries[name]; ok {
+		s.ll.MoveToFront(e)
+	}
+	s.mu.Unlock()
+	return b, true
+}
+
+// Set stores the value for the key, evicting the least recently used entries if the storage is full.
+// The file is replaced atomically so concurrent readers never see a partial value.
+func (s *DiskCacheStorage) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(value)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	name := s.name(key)
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(name)
+	s.entries[name] = s.ll.PushFront(&diskCacheItem{name: name, size: int64(len(value))})
+	s.size += int64(len(value))
+	s.evict()
+}
+
+// Delete removes any value stored for the key.
+func (s *DiskCacheStorage) Delete(key string) {
+	name := s.name(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(name)
+	os.Remove(filepath.Join(s.dir, name))
+}
+
+// Len returns the number of entries held.
+func (s *DiskCacheStorage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// evict removes the least recently used entries until the storage is within its limits. The lock must be held.
+func (s *DiskCacheStorage) evict() {
+	for s.ll.Len() > 0 && (s.maxEntries > 0 && s.ll.Len() > s.maxEntries || s.maxSize > 0 && s.size > s.maxSize) {
+		item := s.ll.Back().Value.(*diskCacheItem)
+		s.forget(item.name)
+		os.Remove(filepath.Join(s.dir, item.name))
+	}
+}
+
+// forget removes the entry from the index. The lock must be held.
+func (s *DiskCacheStorage) forget(name string) {
+	if e, ok := s.entries[name]; ok {
+		s.size -= e.Value.(*diskCacheItem).size
+		s.ll.Remove(e)
+		delete(s.entries, name)
+	}
+}
+
+func (s *DiskCacheStorage) name(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,93 @@
+package restclient
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryCacheStorage(t *testing.T) {
+	s := NewMemoryCacheStorage(2)
+	s.Set("a", []byte("1"))
+	s.Set("b", []byte("2"))
+	v, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), v)
+
+	// b is now the least recently used
+	s.Set("c", []byte("3"))
+	_, ok = s.Get("b")
+	assert.False(t, ok, "Least recently used entry not evicted")
+	assert.Equal(t, 2, s.Len())
+
+	s.Set("a", []byte("4"))
+	v, _ = s.Get("a")
+	assert.Equal(t, []byte("4"), v, "Value not replaced")
+
+	s.Delete("a")
+	_, ok = s.Get("a")
+	assert.False(t, ok, "Entry not deleted")
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestDiskCacheStorage(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "restclient-TestDiskCacheStorage")
+	defer os.RemoveAll(dir)
+
+	s, err := NewDiskCacheStorage(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Error creating disk storage: %v", err)
+	}
+	_, ok := s.Get("GET http://test/a")
+	assert.False(t, ok)
+	s.Set("GET http://test/a", []byte("1"))
+	s.Set("GET http://test/a", []byte("2"))
+	v, ok := s.Get("GET http://test/a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("2"), v, "Value not replaced")
+
+	// A second storage on the same directory sees the same entries
+	s2, _ := NewDiskCacheStorage(filepath.Join(dir, "cache"))
+	v, ok = s2.Get("GET http://test/a")
+	assert.True(t, ok, "Entry not persisted to disk")
+	assert.Equal(t, []byte("2"), v)
+
+	s.Delete("GET http://test/a")
+	_, ok = s2.Get("GET http://test/a")
+	assert.False(t, ok, "Entry not deleted")
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	assert.Len(t, files, 0, "Temporary files left in the cache directory")
+}
+
+func TestDiskCacheStorage_Limits(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "restclient-TestDiskCacheStorage")
+	defer os.RemoveAll(dir)
+
+	s, _ := NewDiskCacheStorage(dir)
+	s.WithMaxEntries(2)
+	s.Set("a", []byte("1"))
+	s.Set("b", []byte("2"))
+	s.Get("a")
+	// b is now the least recently used
+	s.Set("c", []byte("3"))
+	_, ok := s.Get("b")
+	assert.False(t, ok, "Least recently used entry not evicted")
+	assert.Equal(t, 2, s.Len())
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 2, "Evicted entry not removed from the cache directory")
+
+	// Entries already in the directory count towards the limits
+	s2, _ := NewDiskCacheStorage(dir)
+	assert.Equal(t, 2, s2.Len())
+	s2.WithMaxSize(10)
+	s2.Set("d", []byte("0123456789"))
+	assert.Equal(t, 1, s2.Len(), "Entries not evicted to keep within the size limit")
+	_, ok = s2.Get("d")
+	assert.True(t, ok)
+	s2.Set("e", []byte("01234567890"))
+	assert.Equal(t, 0, s2.Len(), "Entry larger than the size limit should not be kept")
+	files, _ = ioutil.ReadDir(dir)
+	assert.Len(t, files, 0)
+}
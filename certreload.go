@@ -0,0 +1,273 @@
+package restclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reload the CA certificate and client certificate files referenced in the config when they change, so running clients pick up
+// rotated certificates without a restart. The files are checked for changes at most once per interval, when a TLS connection is made.
+// If the new files cannot be loaded, for example while a certificate and key are part way through being replaced, the certificates
+// already loaded continue to be used and the files are tried again at the next check.
+//
+// CA and client certificates configured after this is called are used, and their files reloaded, as well.
+func (c *Config) WithCertificateReload(interval time.Duration) *Config {
+	cfg := c.tlsClientConfig()
+	r := &certReloader{
+		config:   c,
+		interval: interval,
+		now:      time.Now,
+		stamps:   make(map[string]fileStamp),
+		roots:    cfg.RootCAs,
+		cert:     c.clientCert,
+	}
+	// Files that cannot be loaded now are left unstamped so they are tried again at the next check
+	r.load(r.now())
+	r.checked = r.now()
+	// GetClientCertificate is only used when Certificates is empty
+	cfg.Certificates = nil
+	cfg.GetClientCertificate = r.getClientCertificate
+	// The roots change so the certificate chain is verified here rather than with the fixed RootCAs.
+	// Verification that has been turned off is left off.
+	if !cfg.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = c.verifyConnection
+		r.verifies = true
+		transport := c.HTTPClient.Transport.(*http.Transport)
+		transport.DialTLSContext = r.dialTLS(transport)
+	}
+	c.certReloader = r
+	return c
+}
+
+// A certReloader holds the certificates loaded from the files referenced in a config, reloading them when the files change.
+type certReloader struct {
+	config   *Config
+	interval time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	checked  time.Time
+	stamps   map[string]fileStamp
+	roots    *x509.CertPool
+	cert     *tls.Certificate
+	verifies bool
+}
+
+// A fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// caFiles returns the CA certificate files, and the directories holding them so that added and removed files are seen.
+func (r *certReloader) caFiles() []string {
+	paths := r.config.caFilePaths()
+	files, _ := caFiles(paths)
+	return append(paths, files...)
+}
+
+func (r *certReloader) certFiles() []string {
+	c := r.config
+	if c.ClientPKCS12 != nil {
+		return []string{*c.ClientPKCS12}
+	}
+	if c.ClientCert != nil && c.ClientKey != nil {
+		return []string{*c.ClientCert, *c.ClientKey}
+	}
+	return nil
+}
+
+// changed reports whether any of the files differ from when they were last loaded.
+func (r *certReloader) changed(paths []string) bool {
+	for _, p := range paths {
+		if r.stamps[p] != stamp(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) stampFiles(paths ...[]string) {
+	for _, ps := range paths {
+		for _, p := range ps {
+			r.stamps[p] = stamp(p)
+		}
+	}
+}
+
+func stamp(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// reload loads any of the files that have changed if the interval has passed since they were last checked.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Sub(r.checked) < r.interval {
+		return nil
+	}
+	r.checked = now
+	return r.load(now)
+}
+
+// load loads any of the files that have changed, stamping them only once they have been loaded. The lock must be held.
+func (r *certReloader) load(now time.Time) error {
+	var err error
+	if ca := r.caFiles(); r.changed(ca) {
+		var certs []*x509.Certificate
+		if certs, err = loadCAFiles(r.config.caFilePaths()); err == nil {
+			var cp *x509.CertPool
+			if cp, err = r.config.rootCAPool(certs); err == nil {
+				r.roots = cp
+				r.stampFiles(ca)
+			}
+		}
+	}
+	if files := r.certFiles(); r.changed(files) {
+		var cert tls.Certificate
+		var cerr error
+		if r.config.ClientPKCS12 != nil {
+			cert, cerr = loadClientPKCS12File(files[0], r.password())
+		} else {
+			cert, cerr = loadClientCertFiles(files[0], files[1], r.password())
+		}
+		if cerr == nil {
+			cerr = checkClientCertificate(&cert, now)
+		}
+		if cerr == nil {
+			r.cert = &cert
+			r.stampFiles(files)
+		} else if err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Certificates could not be reloaded; %v", err)
+	}
+	return nil
+}
+
+func (r *certReloader) password() string {
+	if r.config.ClientKeyPassword == nil {
+		return ""
+	}
+	return *r.config.ClientKeyPassword
+}
+
+// clientCertificate returns the client certificate currently loaded.
+func (r *certReloader) clientCertificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert
+}
+
+// setRoots replaces the CA certificates, when they are configured after reloading was enabled.
+func (r *certReloader) setRoots(cp *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.roots = cp
+}
+
+// setClientCertificate replaces the client certificate, when it is configured after reloading was enabled.
+func (r *certReloader) setClientCertificate(cert *tls.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = cert
+}
+
+// verifiesChain reports whether the reloader verifies the ReST service's certificate chain.
+func (r *certReloader) verifiesChain() bool {
+	return r.verifies
+}
+
+func (r *certReloader) rootCAs() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.roots
+}
+
+// getClientCertificate is used as the tls.Config GetClientCertificate callback.
+func (r *certReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	// Reload failures keep the previous certificate in use
+	r.reload()
+	if cert := r.clientCertificate(); cert != nil {
+		return cert, nil
+	}
+	// No certificate is sent
+	return &tls.Certificate{}, nil
+}
+
+// dialTLS returns a function that connects to the ReST service with the transport's TLS settings, for the transport's DialTLSContext.
+func (r *certReloader) dialTLS(t *http.Transport) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := t.TLSClientConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = host
+		}
+		// The host dialled is not in the connection state when it is an IP address, so is given to the callback here
+		name := cfg.ServerName
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.config.verifyConnectionTo(name, cs)
+		}
+		dial := (&net.Dialer{}).DialContext
+		if t.DialContext != nil {
+			dial = t.DialContext
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if t.TLSHandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, t.TLSHandshakeTimeout)
+			defer cancel()
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
+
+// verifyChain verifies the ReST service's certificate chain for the name given against the current CA certificates, returning the verified chains.
+func (r *certReloader) verifyChain(name string, cs tls.ConnectionState) ([][]*x509.Certificate, error) {
+	r.reload()
+	if r.config.PinOnly {
+		// The certificate is checked against the pinned public keys instead
+		return nil, nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return nil, errors.New("ReST service presented no certificate")
+	}
+	if name == "" {
+		return nil, errors.New("Name of the ReST service is not known to verify its certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.rootCAs(),
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return cs.PeerCertificates[0].Verify(opts)
+}
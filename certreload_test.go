@@ -0,0 +1,209 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// rotated marks a file as changed by moving its modification time forward.
+func rotated(t *testing.T, path string, n int) {
+	mt := time.Now().Add(time.Duration(n) * time.Minute)
+	if err := os.Chtimes(path, mt, mt); err != nil {
+		t.Fatalf("Error changing the modification time of %s: %v", path, err)
+	}
+}
+
+// writeClientCert writes a client certificate issued by the CA to the certificate and key files given.
+func writeClientCert(t *testing.T, ca *testCA, name, dir string) (string, string) {
+	cert := ca.clientCert(t, name, time.Now().Add(time.Hour))
+	key, _ := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	return writePEM(t, dir, "client.crt", "CERTIFICATE", cert.Certificate[0]),
+		writePEM(t, dir, "client.key", "PRIVATE KEY", key)
+}
+
+func TestConfig_WithCertificateReload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	certPath, keyPath := writeClientCert(t, ca, "client-1", dir)
+
+	c := NewConfig().WithEndPoint(s.URL).WithCAFilePath(caPath).WithClientCertFiles(certPath, keyPath).WithCertificateReload(0)
+	assert.Nil(t, c.Validate())
+	f, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-1", f)
+
+	// A rotated client certificate is presented on new connections
+	writeClientCert(t, ca, "client-2", dir)
+	rotated(t, certPath, 1)
+	rotated(t, keyPath, 1)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-2", f, "Rotated client certificate not presented")
+	assert.Equal(t, "client-2", c.currentClientCert().Leaf.Subject.CommonName)
+
+	// A certificate without its matching key is not loaded and the previous certificate is kept
+	prevKey, _ := ioutil.ReadFile(keyPath)
+	writeClientCert(t, ca, "client-3", dir)
+	ioutil.WriteFile(keyPath, prevKey, 0600)
+	rotated(t, certPath, 2)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-2", f, "Previous client certificate should be kept when the new one cannot be loaded")
+	assert.NotNil(t, c.certReloader.reload(), "Mismatched certificate and key should be reported")
+	writeClientCert(t, ca, "client-3", dir)
+	rotated(t, certPath, 3)
+	rotated(t, keyPath, 3)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-3", f, "Client certificate not loaded once the key was replaced")
+
+	// A ReST service with a certificate from a new CA is trusted once the CA file is updated
+	ca2 := newTestCA(t, "Test CA 2")
+	s2 := newMTLSServer(t, ca2)
+	defer s2.Close()
+	c.WithEndPoint(s2.URL)
+	_, err = sendField(c)
+	assert.NotNil(t, err, "Service certificate from an untrusted CA should be rejected")
+	writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw, ca2.cert.Raw)
+	rotated(t, caPath, 4)
+	writeClientCert(t, ca2, "client-4", dir)
+	rotated(t, certPath, 4)
+	rotated(t, keyPath, 4)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-4", f)
+
+	// A CA file that cannot be loaded keeps the previous CA certificates
+	ioutil.WriteFile(caPath, []byte("not PEM"), 0600)
+	rotated(t, caPath, 5)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-4", f, "Previous CA certificates should be kept when the CA file cannot be loaded")
+}
+
+func TestConfig_WithCertificateReload_Interval(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	certPath, keyPath := writeClientCert(t, ca, "client-1", dir)
+
+	c := NewConfig().WithEndPoint(s.URL).WithCAFilePath(caPath).WithClientCertFiles(certPath, keyPath).WithCertificateReload(time.Minute)
+	now := time.Now()
+	c.certReloader.now = func() time.Time { return now }
+
+	writeClientCert(t, ca, "client-2", dir)
+	rotated(t, certPath, 1)
+	rotated(t, keyPath, 1)
+	f, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-1", f, "Files should not be checked again within the interval")
+
+	now = now.Add(time.Minute)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-2", f, "Files not checked after the interval")
+}
+
+func TestConfig_WithCertificateReload_Order(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	certPath, keyPath := writeClientCert(t, ca, "client-1", dir)
+
+	// Certificates configured after reloading is enabled are used and reloaded
+	c := NewConfig().WithEndPoint(s.URL).WithCertificateReload(0).WithCAFilePath(caPath).WithClientCertFiles(certPath, keyPath)
+	assert.Nil(t, c.Validate())
+	f, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-1", f, "Certificates configured after reloading was enabled not used")
+	writeClientCert(t, ca, "client-2", dir)
+	rotated(t, certPath, 1)
+	rotated(t, keyPath, 1)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client-2", f, "Client certificate configured after reloading was enabled not reloaded")
+
+	// A CA pool given later replaces the CA certificates loaded
+	ca2 := newTestCA(t, "Test CA 2")
+	s2 := newMTLSServer(t, ca2)
+	defer s2.Close()
+	cp := x509.NewCertPool()
+	cp.AddCert(ca2.cert)
+	c.WithEndPoint(s2.URL).WithCACertPool(cp).WithClientCertificate(ca2.clientCert(t, "client-3", time.Now().Add(time.Hour)))
+	f, err = sendField(c)
+	assert.Nil(t, err, "CA pool configured after reloading was enabled not used")
+	assert.Equal(t, "client-3", f)
+}
+
+func TestConfig_WithCertificateReload_InitialFailure(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	certPath, keyPath := writeClientCert(t, ca, "client-1", dir)
+	ioutil.WriteFile(caPath, []byte("not PEM"), 0600)
+
+	c := NewConfig().WithEndPoint(s.URL).WithCAFilePath(caPath).WithClientCertFiles(certPath, keyPath).WithCertificateReload(0)
+	assert.NotNil(t, c.Validate())
+	assert.NotNil(t, c.certReloader.reload(), "File that could not be loaded initially should be tried again")
+	writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	f, err := sendField(c)
+	assert.Nil(t, err, "CA file not loaded once it was fixed")
+	assert.Equal(t, "client-1", f)
+}
+
+func TestConfig_WithCertificateReload_Host(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+
+	// The certificate is for 127.0.0.1 so is not valid for a service on another IP address
+	l, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("Cannot listen on a second loopback address: %v", err)
+	}
+	other := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Field": "other"}`)
+	}))
+	other.Listener.Close()
+	other.Listener = l
+	other.TLS = &tls.Config{Certificates: []tls.Certificate{ca.serverCert(t)}}
+	other.StartTLS()
+	defer other.Close()
+	s := newTLSServer(t, ca)
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithCAFilePath(caPath).WithCertificateReload(time.Minute)
+	_, err = sendField(c)
+	assert.Nil(t, err)
+	s.Config.Handler = http.RedirectHandler(other.URL, http.StatusFound)
+	_, err = sendField(c)
+	assert.NotNil(t, err, "Certificate of the host redirected to should be verified for that host rather than the endpoint")
+
+	// Without the name of the service its certificate is not accepted
+	cert := ca.serverCert(t)
+	err = c.verifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf, ca.cert}})
+	assert.NotNil(t, err, "Certificate verified without a name")
+}
@@ -0,0 +1,165 @@
+package restclient
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/youmark/pkcs8"
+	"io/ioutil"
+	"software.sslmate.com/src/go-pkcs12"
+	"time"
+)
+
+// How long before a client certificate expires that Warnings reports it.
+const ClientCertExpiryWarning = 30 * 24 * time.Hour
+
+// Present the certificate given to the ReST service for mutual TLS authentication.
+func (c *Config) WithClientCertificate(cert tls.Certificate) *Config {
+	c.clientCert = &cert
+	if c.certReloader != nil {
+		// The reloader provides the certificate
+		c.certReloader.setClientCertificate(&cert)
+		return c
+	}
+	c.tlsClientConfig().Certificates = []tls.Certificate{cert}
+	return c
+}
+
+// Present a client certificate for mutual TLS authentication, loaded from PEM files that may include the intermediate certificates.
+func (c *Config) WithClientCertFiles(certPEM, keyPEM string) *Config {
+	return c.WithEncryptedClientCertFiles(certPEM, keyPEM, "")
+}
+
+// Present a client certificate for mutual TLS authentication, loaded from PEM files with a private key that may be encrypted PKCS#8.
+func (c *Config) WithEncryptedClientCertFiles(certPEM, keyPEM, password string) *Config {
+	c.ClientCert = &certPEM
+	c.ClientKey = &keyPEM
+	if password != "" {
+		c.ClientKeyPassword = &password
+	}
+	cert, err := loadClientCertFiles(certPEM, keyPEM, password)
+	if err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
+		return c
+	}
+	return c.WithClientCertificate(cert)
+}
+
+// Present a client certificate for mutual TLS authentication, loaded from a PKCS#12 (.p12 or .pfx) file protected by the password given.
+func (c *Config) WithClientPKCS12File(path, password string) *Config {
+	c.ClientPKCS12 = &path
+	if password != "" {
+		c.ClientKeyPassword = &password
+	}
+	cert, err := loadClientPKCS12File(path, password)
+	if err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
+		return c
+	}
+	return c.WithClientCertificate(cert)
+}
+
+// Warnings returns problems with the config that do not stop it being used, such as a client certificate that will soon expire.
+func (c *Config) Warnings() (warnings error) {
+	if cert := c.currentClientCert(); cert != nil && cert.Leaf != nil {
+		if exp := cert.Leaf.NotAfter; time.Until(exp) < ClientCertExpiryWarning {
+			warnings = multierror.Append(warnings, fmt.Errorf("Client certificate %s expires on %s", cert.Leaf.Subject, exp.Format(time.RFC3339)))
+		}
+	}
+	if len(c.PinnedPublicKeys) == 1 {
+		warnings = multierror.Append(warnings, errors.New("Only one public key is pinned, without a backup pin the ReST service's key cannot be replaced"))
+	}
+	return
+}
+
+// currentClientCert returns the client certificate being presented, which may have been reloaded.
+func (c *Config) currentClientCert() *tls.Certificate {
+	if c.certReloader != nil {
+		if cert := c.certReloader.clientCertificate(); cert != nil {
+			return cert
+		}
+	}
+	return c.clientCert
+}
+
+// loadClientCertFiles loads a certificate chain and private key from PEM files, decrypting the key if it is encrypted PKCS#8.
+func loadClientCertFiles(certPath, keyPath, password string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Client certificate could not be read from file; %v", err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Client private key could not be read from file; %v", err)
+	}
+	if block, _ := pem.Decode(keyPEM); block != nil && block.Type == "ENCRYPTED PRIVATE KEY" {
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("Client private key could not be decrypted; %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("Client private key type not supported; %v", err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Client certificate could not be loaded; %v", err)
+	}
+	return cert, nil
+}
+
+// loadClientPKCS12File loads a certificate chain and private key from a PKCS#12 file.
+func loadClientPKCS12File(path, password string) (tls.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("PKCS#12 file could not be read; %v", err)
+	}
+	key, leaf, cas, err := pkcs12.DecodeChain(b, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("PKCS#12 file could not be decoded; %v", err)
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range cas {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+// checkClientCertificate checks that the private key of the certificate matches it and that it is valid at the time given.
+func checkClientCertificate(cert *tls.Certificate, now time.Time) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("Client certificate is empty")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("Client certificate could not be parsed; %v", err)
+		}
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("Client certificate has no usable private key")
+	}
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return errors.New("Client private key does not match the certificate")
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("Client certificate %s expired on %s", leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("Client certificate %s is not valid until %s", leaf.Subject, leaf.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
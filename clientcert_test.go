@@ -0,0 +1,281 @@
+package restclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/youmark/pkcs8"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"time"
+)
+
+// A testCA is a certificate authority that issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var testSerial int64
+
+func newTestCA(t *testing.T, name string) *testCA {
+	ca := &testCA{}
+	ca.cert, ca.key = ca.issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	})
+	return ca
+}
+
+// issue creates a certificate from the template, signed by the CA, or self-signed if the CA has no certificate yet.
+// The validity period defaults to an hour either side of now.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating test key: %v", err)
+	}
+	testSerial++
+	tmpl.SerialNumber = big.NewInt(testSerial)
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+	}
+	parent, signer := tmpl, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Error creating test certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// serverCert returns a certificate for a TLS server on 127.0.0.1.
+func (ca *testCA) serverCert(t *testing.T) tls.Certificate {
+	cert, key := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:    []string{"example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return tls.Certificate{Certificate: [][]byte{cert.Raw, ca.cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+// clientCert returns a client certificate for the name given, valid until the time given.
+func (ca *testCA) clientCert(t *testing.T, name string, notAfter time.Time) tls.Certificate {
+	cert, key := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		NotAfter:    notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+// writePEM writes the DER blocks given to a PEM file in the directory and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der ...[]byte) string {
+	path := filepath.Join(dir, name)
+	var b []byte
+	for _, d := range der {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: d})...)
+	}
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", path, err)
+	}
+	return path
+}
+
+// newTLSServer starts a TLS server with a certificate issued by the CA.
+func newTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Field": "value"}`)
+	}))
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{ca.serverCert(t)}}
+	s.StartTLS()
+	return s
+}
+
+// newMTLSServer starts a TLS server, with a certificate issued by the CA, that requires client certificates issued by the CA.
+// It responds with the common name of the client's certificate.
+func newMTLSServer(t *testing.T, ca *testCA) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Field": %q}`, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	cp := x509.NewCertPool()
+	cp.AddCert(ca.cert)
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.serverCert(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cp,
+	}
+	s.StartTLS()
+	return s
+}
+
+// sendField sends a GET request using the config and returns the Field of the response.
+func sendField(c *Config) (string, error) {
+	var d struct {
+		Field string
+	}
+	r, err := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	if err != nil {
+		return "", err
+	}
+	_, err = Send(r)
+	return d.Field, err
+}
+
+func TestConfig_WithClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert)
+	_, err := sendField(c)
+	assert.NotNil(t, err, "Request without a client certificate should fail")
+
+	// The CA set afterwards does not replace the client certificate
+	c = NewConfig().WithEndPoint(s.URL).WithClientCertificate(ca.clientCert(t, "client1", time.Time{})).WithCACert(ca.cert)
+	name, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client1", name, "Client certificate not presented")
+
+	other := newTestCA(t, "Other CA")
+	c = NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithClientCertificate(other.clientCert(t, "client2", time.Time{}))
+	_, err = sendField(c)
+	assert.NotNil(t, err, "Client certificate from another CA should not be accepted")
+}
+
+func TestConfig_WithClientCertFiles(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+	dir, _ := ioutil.TempDir(os.TempDir(), "restclient-TestConfig_WithClientCertFiles")
+	defer os.RemoveAll(dir)
+
+	cert := ca.clientCert(t, "client1", time.Time{})
+	certPath := writePEM(t, dir, "client.pem", "CERTIFICATE", cert.Certificate[0])
+	der, _ := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	keyPath := writePEM(t, dir, "client.key", "PRIVATE KEY", der)
+	encrypted, _ := pkcs8.MarshalPrivateKey(cert.PrivateKey, []byte("secret"), nil)
+	encryptedPath := writePEM(t, dir, "client-enc.key", "ENCRYPTED PRIVATE KEY", encrypted)
+	otherKey := ca.clientCert(t, "client2", time.Time{}).PrivateKey
+	der, _ = x509.MarshalPKCS8PrivateKey(otherKey)
+	otherKeyPath := writePEM(t, dir, "other.key", "PRIVATE KEY", der)
+
+	c := NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithClientCertFiles(certPath, keyPath)
+	assert.Nil(t, c.configErr)
+	name, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client1", name, "Client certificate not presented")
+
+	c = NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithEncryptedClientCertFiles(certPath, encryptedPath, "secret")
+	assert.Nil(t, c.configErr)
+	name, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client1", name, "Client certificate with encrypted key not presented")
+
+	c = NewConfig().WithEncryptedClientCertFiles(certPath, encryptedPath, "wrong")
+	assert.NotNil(t, c.configErr, "Wrong password should cause an error")
+	c = NewConfig().WithClientCertFiles(certPath, encryptedPath)
+	assert.NotNil(t, c.configErr, "Encrypted key without a password should cause an error")
+	c = NewConfig().WithClientCertFiles(certPath, otherKeyPath)
+	assert.NotNil(t, c.configErr, "Key not matching the certificate should cause an error")
+	c = NewConfig().WithClientCertFiles(filepath.Join(dir, "missing.pem"), keyPath)
+	assert.NotNil(t, c.configErr, "Missing certificate file should cause an error")
+}
+
+func TestConfig_WithClientPKCS12File(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	s := newMTLSServer(t, ca)
+	defer s.Close()
+	dir, _ := ioutil.TempDir(os.TempDir(), "restclient-TestConfig_WithClientPKCS12File")
+	defer os.RemoveAll(dir)
+
+	cert := ca.clientCert(t, "client1", time.Time{})
+	pfx, err := pkcs12.Modern.Encode(cert.PrivateKey, cert.Leaf, []*x509.Certificate{ca.cert}, "secret")
+	if err != nil {
+		t.Fatalf("Error encoding PKCS#12: %v", err)
+	}
+	path := filepath.Join(dir, "client.p12")
+	ioutil.WriteFile(path, pfx, 0600)
+
+	c := NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithClientPKCS12File(path, "secret")
+	assert.Nil(t, c.configErr)
+	assert.Equal(t, 2, len(c.clientCert.Certificate), "CA certificates in the bundle not included in the chain")
+	name, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "client1", name, "Client certificate from PKCS#12 not presented")
+
+	c = NewConfig().WithClientPKCS12File(path, "wrong")
+	assert.NotNil(t, c.configErr, "Wrong password should cause an error")
+}
+
+func TestConfig_ValidateClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := ca.clientCert(t, "client1", time.Time{})
+	c := NewConfig().WithEndPoint("http://example.com").WithClientCertificate(cert)
+	assert.Nil(t, c.Validate())
+	assert.NotNil(t, c.Warnings(), "Certificate expiring within an hour should be warned about")
+
+	c = NewConfig().WithEndPoint("http://example.com").WithClientCertificate(ca.clientCert(t, "client1", time.Now().Add(90*24*time.Hour)))
+	assert.Nil(t, c.Validate())
+	assert.Nil(t, c.Warnings())
+
+	mismatched := cert
+	mismatched.PrivateKey = ca.clientCert(t, "client2", time.Time{}).PrivateKey
+	c = NewConfig().WithEndPoint("http://example.com").WithClientCertificate(mismatched)
+	assert.NotNil(t, c.Validate(), "Key not matching the certificate should not validate")
+
+	c = NewConfig().WithEndPoint("http://example.com").WithClientCertificate(ca.clientCert(t, "client1", time.Now().Add(-time.Minute)))
+	assert.NotNil(t, c.Validate(), "Expired certificate should not validate")
+
+	var cfg Config
+	cfg.ClientCert = new(string)
+	assert.NotNil(t, cfg.Validate(), "Client certificate without a key should not validate")
+}
+
+func TestConfig_LoadClientCertificate(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	dir, _ := ioutil.TempDir(os.TempDir(), "restclient-TestConfig_LoadClientCertificate")
+	defer os.RemoveAll(dir)
+	cert := ca.clientCert(t, "client1", time.Time{})
+	certPath := writePEM(t, dir, "client.pem", "CERTIFICATE", cert.Certificate[0])
+	encrypted, _ := pkcs8.MarshalPrivateKey(cert.PrivateKey, []byte("secret"), nil)
+	keyPath := writePEM(t, dir, "client.key", "ENCRYPTED PRIVATE KEY", encrypted)
+	pfx, _ := pkcs12.Modern.Encode(cert.PrivateKey, cert.Leaf, nil, "secret")
+	pfxPath := filepath.Join(dir, "client.p12")
+	ioutil.WriteFile(pfxPath, pfx, 0600)
+
+	for _, cfgJSON := range []string{
+		fmt.Sprintf(`{"EndPoint": "http://testurl", "ClientCert": %q, "ClientKey": %q, "ClientKeyPassword": "secret"}`, certPath, keyPath),
+		fmt.Sprintf(`{"EndPoint": "http://testurl", "ClientPKCS12": %q, "ClientKeyPassword": "secret"}`, pfxPath),
+	} {
+		path := filepath.Join(dir, "config.json")
+		ioutil.WriteFile(path, []byte(cfgJSON), 0600)
+		c := Load(path)
+		assert.Nil(t, c.Validate(), cfgJSON)
+		if assert.NotNil(t, c.clientCert, cfgJSON) {
+			assert.Equal(t, cert.Certificate[0], c.clientCert.Certificate[0], "Client certificate not loaded")
+		}
+		assert.Equal(t, 1, len(c.HTTPClient.Transport.(*http.Transport).TLSClientConfig.Certificates))
+	}
+}
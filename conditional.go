@@ -0,0 +1,115 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Default number of times ReadModifyWrite attempts the update.
+const DefaultReadModifyWriteAttempts = 3
+
+// ErrPreconditionFailed is matched by errors.Is for a *PreconditionFailedError.
+var ErrPreconditionFailed = errors.New("Precondition failed, the resource has been modified")
+
+// A PreconditionFailedError is returned when the ReST service responds with 412 Precondition Failed, or ReadModifyWrite's write with 409 Conflict.
+type PreconditionFailedError struct {
+	StatusCode int
+	// The current ETag of the resource, if the response included one
+	ETag string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("Precondition failed with HTTP status %d, the resource has been modified", e.StatusCode)
+}
+
+// Is reports whether the target is ErrPreconditionFailed.
+func (e *PreconditionFailedError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
+
+// Only perform the Operation if the resource's current ETag matches the one given. Use "*" to require that the resource exists.
+func (o *Operation) WithIfMatch(etag string) *Operation {
+	return o.WithHeader("If-Match", etag)
+}
+
+// Only perform the Operation if the resource's current ETag does not match the one given. Use "*" to require that the resource does not exist.
+func (o *Operation) WithIfNoneMatch(etag string) *Operation {
+	return o.WithHeader("If-None-Match", etag)
+}
+
+// Only perform the Operation if the resource has not been modified since the time given.
+func (o *Operation) WithIfUnmodifiedSince(t time.Time) *Operation {
+	return o.WithHeader("If-Unmodified-Since", t.UTC().Format(http.TimeFormat))
+}
+
+// Only perform the Operation if the resource has been modified since the time given.
+func (o *Operation) WithIfModifiedSince(t time.Time) *Operation {
+	return o.WithHeader("If-Modified-Since", t.UTC().Format(http.TimeFormat))
+}
+
+// hasPrecondition returns whether a conditional header has been set on the Operation.
+func (o *Operation) hasPrecondition() bool {
+	for _, h := range []string{"If-Match", "If-None-Match", "If-Unmodified-Since", "If-Modified-Since"} {
+		if o.header.Get(h) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Read a resource, modify it and write it back, retrying if it is changed by someone else in between.
+//
+// The read Operation is sent and its response decoded into its response target.
+// modify is then called with the read Request and must return a new write Operation, typically a PUT or PATCH built from the response target.
+// If the write Operation has no precondition set, If-Match is set to the ETag of the read response, or If-Unmodified-Since to its Last-Modified time if there is no ETag.
+// If the write fails with 412 Precondition Failed or 409 Conflict the whole cycle is repeated, up to maxAttempts times in total.
+// The write Request is returned. A 4xx or 5xx response to the read or write results in an error, a *PreconditionFailedError if every attempt conflicted.
+func ReadModifyWrite(ctx context.Context, c *Config, read *Operation, modify func(current *Request) (*Operation, error), maxAttempts int) (w *Request, err error) {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultReadModifyWriteAttempts
+	}
+	var conflict *PreconditionFailedError
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		r, err := BuildRequest(c, read)
+		if err != nil {
+			return nil, err
+		}
+		code, err := SendContext(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if *code >= 400 {
+			return nil, fmt.Errorf("Read received HTTP status %d from the ReST service", *code)
+		}
+		o, err := modify(r)
+		if err != nil {
+			return nil, err
+		}
+		if !o.hasPrecondition() {
+			if r.ETag != "" {
+				o.WithIfMatch(r.ETag)
+			} else if !r.LastModified.IsZero() {
+				o.WithIfUnmodifiedSince(r.LastModified)
+			}
+		}
+		w, err = BuildRequest(c, o)
+		if err != nil {
+			return nil, err
+		}
+		code, err = SendContext(ctx, w)
+		if err == nil && *code == http.StatusConflict {
+			err = &PreconditionFailedError{StatusCode: *code, ETag: w.ETag}
+		}
+		if errors.As(err, &conflict) {
+			continue
+		}
+		if err == nil && *code >= 400 {
+			err = fmt.Errorf("Write received HTTP status %d from the ReST service", *code)
+		}
+		return w, err
+	}
+	return w, fmt.Errorf("Resource was modified by another writer on each of %d attempts; %w", maxAttempts, conflict)
+}
@@ -0,0 +1,179 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type counterResource struct {
+	Count int `json:"count"`
+}
+
+// counterServer holds a counter resource that can only be replaced with a matching If-Match header.
+// interfere is called before each PUT is processed so tests can simulate another writer.
+type counterServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	version   int
+	count     int
+	puts      int
+	gets      int
+	interfere func(s *counterServer)
+}
+
+func newCounterServer() *counterServer {
+	s := &counterServer{version: 1}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case "GET":
+			s.gets++
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, s.version))
+			json.NewEncoder(w).Encode(counterResource{Count: s.count})
+		case "PUT":
+			s.puts++
+			if s.interfere != nil {
+				s.interfere(s)
+			}
+			if r.Header.Get("If-Match") != fmt.Sprintf(`"v%d"`, s.version) {
+				w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, s.version))
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			var c counterResource
+			b, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(b, &c)
+			s.count = c.Count
+			s.version++
+			w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, s.version))
+			json.NewEncoder(w).Encode(c)
+		}
+	}))
+	return s
+}
+
+func TestOperation_Preconditions(t *testing.T) {
+	tm := time.Date(2017, 3, 4, 5, 6, 7, 0, time.FixedZone("X", 3600))
+	o := NewPutOperation().WithIfMatch(`"a"`).WithIfNoneMatch("*").WithIfUnmodifiedSince(tm).WithIfModifiedSince(tm)
+	r, _ := BuildRequest(NewConfig().WithEndPoint("http://test"), o)
+	assert.Equal(t, `"a"`, r.HTTPRequest.Header.Get("If-Match"))
+	assert.Equal(t, "*", r.HTTPRequest.Header.Get("If-None-Match"))
+	assert.Equal(t, "Sat, 04 Mar 2017 04:06:07 GMT", r.HTTPRequest.Header.Get("If-Unmodified-Since"))
+	assert.Equal(t, "Sat, 04 Mar 2017 04:06:07 GMT", r.HTTPRequest.Header.Get("If-Modified-Since"))
+	assert.True(t, o.hasPrecondition())
+	assert.False(t, NewPutOperation().hasPrecondition())
+}
+
+func TestSend_PreconditionFailed(t *testing.T) {
+	s := newCounterServer()
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+
+	var d counterResource
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	Send(r)
+	assert.Equal(t, `"v1"`, r.ETag, "ETag not captured from the response")
+
+	r, _ = BuildRequest(c, NewPutOperation().WithBodyDataStruct(counterResource{Count: 1}).WithIfMatch(`"v0"`))
+	code, err := Send(r)
+	assert.Equal(t, http.StatusPreconditionFailed, *code)
+	assert.True(t, errors.Is(err, ErrPreconditionFailed), "Expected the error to match ErrPreconditionFailed")
+	if pfe, ok := err.(*PreconditionFailedError); assert.True(t, ok, "Expected a PreconditionFailedError on 412") {
+		assert.Equal(t, http.StatusPreconditionFailed, pfe.StatusCode)
+		assert.Equal(t, `"v1"`, pfe.ETag, "Current ETag not included in the error")
+	}
+
+	r, _ = BuildRequest(c, NewPutOperation().WithBodyDataStruct(counterResource{Count: 1}).WithIfMatch(`"v1"`))
+	code, err = Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, `"v2"`, r.ETag)
+}
+
+func TestRequest_LastModified(t *testing.T) {
+	lm := time.Date(2017, 3, 4, 5, 6, 7, 0, time.UTC)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", lm.Format(http.TimeFormat))
+	}))
+	defer s.Close()
+	r, _ := BuildRequest(NewConfig().WithEndPoint(s.URL), NewGetOperation())
+	Send(r)
+	assert.True(t, lm.Equal(r.LastModified), "Last-Modified not captured from the response")
+	assert.Equal(t, "", r.ETag)
+}
+
+func TestReadModifyWrite(t *testing.T) {
+	s := newCounterServer()
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+
+	// Another writer changes the resource while the first update is in progress
+	s.interfere = func(s *counterServer) {
+		if s.puts == 1 {
+			s.count = 10
+			s.version++
+		}
+	}
+	var d counterResource
+	increment := func(current *Request) (*Operation, error) {
+		return NewPutOperation().WithBodyDataStruct(counterResource{Count: d.Count + 1}), nil
+	}
+	w, err := ReadModifyWrite(context.Background(), c, NewGetOperation().WithResponseTarget(&d), increment, 0)
+	assert.Nil(t, err, "Error in read-modify-write")
+	assert.Equal(t, http.StatusOK, w.StatusCode)
+	assert.Equal(t, 2, s.puts, "Expected the write to be retried once")
+	assert.Equal(t, 11, s.count, "Update not applied to the latest version")
+
+	// Every write conflicts
+	s.interfere = func(s *counterServer) {
+		s.version++
+	}
+	s.puts = 0
+	_, err = ReadModifyWrite(context.Background(), c, NewGetOperation().WithResponseTarget(&d), increment, 2)
+	var pfe *PreconditionFailedError
+	assert.True(t, errors.As(err, &pfe), "Expected a PreconditionFailedError after all attempts conflict")
+	assert.True(t, errors.Is(err, ErrPreconditionFailed))
+	assert.Equal(t, 2, s.puts, "Expected the number of attempts to be limited")
+
+	modErr := errors.New("modify failed")
+	_, err = ReadModifyWrite(context.Background(), c, NewGetOperation(), func(current *Request) (*Operation, error) {
+		return nil, modErr
+	}, 0)
+	assert.Equal(t, modErr, err, "Expected the error from modify")
+}
+
+func TestReadModifyWrite_Cache(t *testing.T) {
+	s := newCounterServer()
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL).WithCache(NewCache(NewMemoryCacheStorage(10)))
+
+	var d counterResource
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	Send(r)
+	// Another writer changes the resource so the cached response is out of date
+	s.mu.Lock()
+	s.count = 10
+	s.version++
+	s.mu.Unlock()
+
+	increment := func(current *Request) (*Operation, error) {
+		return NewPutOperation().WithBodyDataStruct(counterResource{Count: d.Count + 1}), nil
+	}
+	w, err := ReadModifyWrite(context.Background(), c, NewGetOperation().WithResponseTarget(&d), increment, 2)
+	assert.Nil(t, err, "Error in read-modify-write with a cache")
+	assert.Equal(t, http.StatusOK, w.StatusCode)
+	assert.Equal(t, 2, s.puts, "Expected the write to be retried once")
+	assert.Equal(t, 2, s.gets, "Expected the read to bypass the cache after the precondition failed")
+	assert.Equal(t, 11, s.count, "Update not applied to the latest version")
+}
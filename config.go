@@ -1,33 +1,77 @@
 package restclient
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	multierror "github.com/hashicorp/go-multierror"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // A Config specifies the details needed to connect to a ReST service
 type Config struct {
-	UserId      *string `json:"UserId,omitempty"`
-	Password    *string `json:"Password,omitempty"`
-	EndPoint    *string
-	TrustCACert *string
-	HTTPClient  *http.Client `json:"-"`
-	configErr   error        `json:"-"`
+	UserId            *string `json:"UserId,omitempty"`
+	Password          *string `json:"Password,omitempty"`
+	BearerToken       *string `json:"BearerToken,omitempty"`
+	EndPoint          *string
+	TrustCACert       *string
+	TrustCACerts      []string            `json:"TrustCACerts,omitempty"`
+	TrustSystemCAs    bool                `json:"TrustSystemCAs,omitempty"`
+	PinnedPublicKeys  []string            `json:"PinnedPublicKeys,omitempty"`
+	PinOnly           bool                `json:"PinOnly,omitempty"`
+	TLS               *TLSSettings        `json:"TLS,omitempty"`
+	CRLFiles          []string            `json:"CRLFiles,omitempty"`
+	RevocationMode    RevocationMode      `json:"RevocationMode,omitempty"`
+	ClientCert        *string             `json:"ClientCert,omitempty"`
+	ClientKey         *string             `json:"ClientKey,omitempty"`
+	ClientKeyPassword *string             `json:"ClientKeyPassword,omitempty"`
+	ClientPKCS12      *string             `json:"ClientPKCS12,omitempty"`
+	HTTPClient        *http.Client        `json:"-"`
+	tokenSource       BearerTokenSource   `json:"-"`
+	auth              Authenticator       `json:"-"`
+	caCerts           []*x509.Certificate `json:"-"`
+	clientCert        *tls.Certificate    `json:"-"`
+	certReloader      *certReloader       `json:"-"`
+	revocation        *revocationChecker  `json:"-"`
+	rateLimiter       RateLimiter         `json:"-"`
+	hedgePolicy       *HedgePolicy        `json:"-"`
+	retryPolicy       *RetryPolicy        `json:"-"`
+	flights           *flightGroup        `json:"-"`
+	cache             *Cache              `json:"-"`
+	responseVerifier  *MessageVerifier    `json:"-"`
+	configErr         error               `json:"-"`
+}
+
+// A RateLimiter limits the rate at which requests are sent to the ReST service.
+// Wait blocks until a request may be sent or returns an error if the context is done first.
+// *rate.Limiter from golang.org/x/time/rate satisfies this interface.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
 }
 
 // Create new, blank ReST client config
 func NewConfig() *Config {
-	cl := http.DefaultClient
-	cl.Transport = http.DefaultTransport
 	return &Config{
-		HTTPClient: cl,
+		HTTPClient: newHTTPClient(),
+	}
+}
+
+// newHTTPClient returns a client with its own copy of the default transport, so that changes made to it by a Config do not affect other users of the defaults.
+func newHTTPClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// Keep enough idle connections to the ReST service for a Batch sending at its default concurrency
+	t.MaxIdleConnsPerHost = DefaultBatchConcurrency
+	return &http.Client{
+		Transport: t,
 	}
 }
 
@@ -43,6 +87,19 @@ func (c *Config) WithPassword(p string) *Config {
 	return c
 }
 
+// Add a bearer token to the config for authentication to the ReST service
+func (c *Config) WithBearerToken(t string) *Config {
+	c.BearerToken = &t
+	return c
+}
+
+// Add a source of bearer tokens to the config for authentication to the ReST service.
+// The source is called for each request sent so tokens that expire can be refreshed.
+func (c *Config) WithBearerTokenSource(s BearerTokenSource) *Config {
+	c.tokenSource = s
+	return c
+}
+
 //Specify the URL endpoint of the ReST service in the form http(s)://hostname:port
 func (c *Config) WithEndPoint(e string) *Config {
 	if strings.HasPrefix(e, "http://") || strings.HasPrefix(e, "https://") {
@@ -56,20 +113,32 @@ func (c *Config) WithEndPoint(e string) *Config {
 // Add a trusted x509 certificate pool to the configuration.
 // If the ReST service implements TLS/SSL then certificates signed by CA certificates in this pool will be trusted.
 func (c *Config) WithCACertPool(cp *x509.CertPool) *Config {
+	c.tlsClientConfig().RootCAs = cp
+	if c.certReloader != nil {
+		c.certReloader.setRoots(cp)
+	}
+	return c
+}
+
+// tlsClientConfig returns the TLS configuration of the HTTP client's transport, creating them if needed.
+// Settings are added to it so that those made before are kept.
+func (c *Config) tlsClientConfig() *tls.Config {
 	if c.HTTPClient == nil {
-		c.HTTPClient = http.DefaultClient
+		c.HTTPClient = newHTTPClient()
 	}
-	if transport, ok := c.HTTPClient.Transport.(*http.Transport); ok {
-		transport.TLSClientConfig = &tls.Config{RootCAs: cp}
-		c.HTTPClient.Transport = transport
-		return c
-	} else {
-		tlsConfig := &tls.Config{RootCAs: cp}
-		transport := &http.Transport{TLSClientConfig: tlsConfig}
+	transport, ok := c.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		if c.HTTPClient.Transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		} else {
+			transport = &http.Transport{}
+		}
 		c.HTTPClient.Transport = transport
-		return c
 	}
-	return c
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	return transport.TLSClientConfig
 }
 
 // Add a trusted x509 certificate to the configuration.
@@ -79,27 +148,145 @@ func (c *Config) WithCACert(cert *x509.Certificate) *Config {
 	if len(cert.Raw) == 0 {
 		c.configErr = multierror.Append(c.configErr, errors.New("CA Certifcate provided is empty"))
 	}
-	cp := x509.NewCertPool()
-	cp.AddCert(cert)
-	return c.WithCACertPool(cp)
+	return c.withCACerts([]*x509.Certificate{cert})
 }
 
 // Add a trusted x509 certificate to the configuration by specifying a path to a PEM format certificate file.
+// The path may also be a directory, in which case the certificates in all the .pem, .crt and .cer files in it are trusted.
 // If the ReST service implements TLS/SSL then certificates signed by this CA certificate will be trusted.
 func (c *Config) WithCAFilePath(caFilePath string) *Config {
-	cp := x509.NewCertPool()
-	// Load our trusted certificate path
-	pemData, err := ioutil.ReadFile(caFilePath)
+	c.TrustCACert = &caFilePath
+	c.TrustCACerts = nil
+	return c.loadCAFiles()
+}
+
+// Add trusted x509 certificates to the configuration from several PEM format certificate files or directories of them.
+func (c *Config) WithCAFilePaths(caFilePaths ...string) *Config {
+	c.TrustCACert = nil
+	c.TrustCACerts = caFilePaths
+	return c.loadCAFiles()
+}
+
+// Trust the CA certificates in the system's trust store as well as those added to the configuration.
+// By default only the CA certificates added are trusted, which stops public ReST services from being used with the same client.
+// A pool given to WithCACertPool is not added to.
+func (c *Config) WithSystemCertPool() *Config {
+	c.TrustSystemCAs = true
+	return c.withCACerts(c.caCerts)
+}
+
+// caFilePaths returns the paths of the trusted CA certificate files and directories.
+func (c *Config) caFilePaths() []string {
+	var paths []string
+	if c.TrustCACert != nil {
+		paths = append(paths, *c.TrustCACert)
+	}
+	return append(paths, c.TrustCACerts...)
+}
+
+func (c *Config) loadCAFiles() *Config {
+	certs, err := loadCAFiles(c.caFilePaths())
 	if err != nil {
-		c.configErr = multierror.Append(c.configErr, fmt.Errorf("CA certificate could not be read from file; %v", err))
+		c.configErr = multierror.Append(c.configErr, err)
 	}
-	ok := cp.AppendCertsFromPEM(pemData)
-	if !ok {
-		c.configErr = multierror.Append(c.configErr, fmt.Errorf("CA certificate could not be loaded from file, is it PEM format? %v", err))
+	return c.withCACerts(certs)
+}
+
+// withCACerts trusts the CA certificates given, and those in the system's trust store if configured.
+func (c *Config) withCACerts(certs []*x509.Certificate) *Config {
+	c.caCerts = certs
+	cp, err := c.rootCAPool(certs)
+	if err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
 	}
 	return c.WithCACertPool(cp)
 }
 
+// rootCAPool returns a pool of the CA certificates given, starting from the system's trust store if configured.
+func (c *Config) rootCAPool(certs []*x509.Certificate) (*x509.CertPool, error) {
+	cp := x509.NewCertPool()
+	var err error
+	if c.TrustSystemCAs {
+		var sp *x509.CertPool
+		if sp, err = x509.SystemCertPool(); err == nil {
+			cp = sp
+		} else {
+			err = fmt.Errorf("System certificate pool could not be loaded; %v", err)
+		}
+	}
+	for _, cert := range certs {
+		cp.AddCert(cert)
+	}
+	return cp, err
+}
+
+// caFiles returns the PEM files at the paths given, expanding directories into the certificate files in them.
+func caFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return files, fmt.Errorf("CA certificate could not be read from file; %v", err)
+		}
+		if !fi.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		entries, err := ioutil.ReadDir(p)
+		if err != nil {
+			return files, fmt.Errorf("CA certificate directory could not be read; %v", err)
+		}
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".pem", ".crt", ".cer":
+				if !e.IsDir() {
+					files = append(files, filepath.Join(p, e.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// loadCAFiles returns the certificates in the PEM format files and directories given.
+func loadCAFiles(paths []string) ([]*x509.Certificate, error) {
+	files, err := caFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, f := range files {
+		// Load our trusted certificate path
+		pemData, err := ioutil.ReadFile(f)
+		if err != nil {
+			return certs, fmt.Errorf("CA certificate could not be read from file; %v", err)
+		}
+		var n int
+		for block, rest := pem.Decode(pemData); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return certs, fmt.Errorf("CA certificate in %s could not be parsed; %v", f, err)
+			}
+			certs = append(certs, cert)
+			n++
+		}
+		if n == 0 {
+			return certs, fmt.Errorf("CA certificate could not be loaded from file %s, is it PEM format?", f)
+		}
+	}
+	return certs, nil
+}
+
+// Limit the rate of requests sent to the ReST service.
+// The limiter is shared by every request sent using this config.
+func (c *Config) WithRateLimiter(l RateLimiter) *Config {
+	c.rateLimiter = l
+	return c
+}
+
 //Override with a specific http.Client to be used for the connection to the ReST service.
 func (c *Config) WithHTTPClient(client http.Client) *Config {
 	c.HTTPClient = &client
@@ -116,16 +303,68 @@ func (c *Config) Validate() (validateErr error) {
 	} else {
 		if !strings.HasPrefix(*c.EndPoint, "http://") && !strings.HasPrefix(*c.EndPoint, "https://") {
 			validateErr = multierror.Append(validateErr, errors.New("Endpoint is neither http:// nor https://"))
-		} else if strings.HasPrefix(*c.EndPoint, "https://") && c.TrustCACert == nil {
+		} else if strings.HasPrefix(*c.EndPoint, "https://") && !c.trustConfigured() {
 			validateErr = multierror.Append(validateErr, errors.New("HTTPS endpoint defined but no trust certificate set"))
 		}
 	}
 	if c.UserId != nil && c.Password == nil {
 		validateErr = multierror.Append(validateErr, errors.New("UserId defined by no password set"))
 	}
+	if c.authMethods() > 1 {
+		validateErr = multierror.Append(validateErr, errors.New("More than one of basic authentication, bearer token and authenticator credentials defined"))
+	}
+	if c.BearerToken != nil && c.tokenSource != nil {
+		validateErr = multierror.Append(validateErr, errors.New("Both a bearer token and a bearer token source defined"))
+	}
+	if c.PinOnly && len(c.PinnedPublicKeys) == 0 {
+		validateErr = multierror.Append(validateErr, errors.New("Pin only verification defined but no public keys pinned"))
+	}
+	if c.ClientCert != nil && c.ClientKey == nil {
+		validateErr = multierror.Append(validateErr, errors.New("Client certificate defined but no private key set"))
+	}
+	if c.ClientCert != nil && c.ClientPKCS12 != nil {
+		validateErr = multierror.Append(validateErr, errors.New("Both a client certificate and a PKCS#12 bundle defined"))
+	}
+	if cert := c.currentClientCert(); cert != nil {
+		if err := checkClientCertificate(cert, time.Now()); err != nil {
+			validateErr = multierror.Append(validateErr, err)
+		}
+	}
 	return
 }
 
+// trustConfigured reports whether the certificates of an HTTPS endpoint can be trusted.
+func (c *Config) trustConfigured() bool {
+	return c.TrustCACert != nil || len(c.TrustCACerts) > 0 || c.TrustSystemCAs || c.PinOnly
+}
+
+// UnmarshalJSON allows TrustCACert to be given as either a path or an array of paths.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	type config Config
+	j := struct {
+		config
+		TrustCACert json.RawMessage
+	}{config: config(*c)}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*c = Config(j.config)
+	if len(j.TrustCACert) == 0 || string(j.TrustCACert) == "null" {
+		return nil
+	}
+	var path string
+	if err := json.Unmarshal(j.TrustCACert, &path); err == nil {
+		c.TrustCACert = &path
+		return nil
+	}
+	var paths []string
+	if err := json.Unmarshal(j.TrustCACert, &paths); err != nil {
+		return fmt.Errorf("TrustCACert is neither a path nor an array of paths; %v", err)
+	}
+	c.TrustCACerts = append(paths, c.TrustCACerts...)
+	return nil
+}
+
 func Load(cfgPath string) *Config {
 	var c Config
 	j, err := ioutil.ReadFile(cfgPath)
@@ -136,9 +375,35 @@ func Load(cfgPath string) *Config {
 	if err != nil {
 		c.configErr = multierror.Append(c.configErr, fmt.Errorf("Configuration file could not be parsed; %v", err))
 	}
-	c.HTTPClient = http.DefaultClient
-	if c.TrustCACert != nil {
-		c.WithCAFilePath(*c.TrustCACert)
+	c.HTTPClient = newHTTPClient()
+	if c.TLS != nil {
+		c.applyTLSSettings(c.TLS)
+	}
+	if len(c.caFilePaths()) > 0 || c.TrustSystemCAs {
+		c.loadCAFiles()
+	}
+	if pins := c.PinnedPublicKeys; len(pins) > 0 {
+		c.PinnedPublicKeys = nil
+		c.WithPinnedPublicKeys(pins...)
+	}
+	if c.PinOnly {
+		c.WithPinOnlyVerification()
+	}
+	if files := c.CRLFiles; len(files) > 0 {
+		c.CRLFiles = nil
+		c.WithCRLFiles(files...)
+	}
+	if c.RevocationMode != "" {
+		c.WithRevocationCheck(c.RevocationMode)
+	}
+	var password string
+	if c.ClientKeyPassword != nil {
+		password = *c.ClientKeyPassword
+	}
+	if c.ClientPKCS12 != nil {
+		c.WithClientPKCS12File(*c.ClientPKCS12, password)
+	} else if c.ClientCert != nil && c.ClientKey != nil {
+		c.WithEncryptedClientCertFiles(*c.ClientCert, *c.ClientKey, password)
 	}
 	return &c
 }
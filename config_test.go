@@ -1,6 +1,7 @@
 package restclient
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -46,6 +48,29 @@ func TestConfig_WithUserId(t *testing.T) {
 	assert.Equal(t, u, *a.UserId, "User not as expected")
 }
 
+func TestConfig_WithBearerToken(t *testing.T) {
+	var c Config
+	a := c.WithBearerToken("token")
+	assert.Equal(t, "token", *a.BearerToken, "Bearer token not as expected")
+}
+
+func TestConfig_WithBearerTokenSource(t *testing.T) {
+	var c Config
+	a := c.WithBearerTokenSource(func(ctx context.Context) (string, error) {
+		return "token", nil
+	})
+	assert.NotNil(t, a.tokenSource, "Bearer token source not set")
+	a.WithEndPoint("http://testurl")
+	assert.Nil(t, a.Validate(), "Configuration with a bearer token source should be valid")
+
+	a.WithBearerToken("token")
+	assert.NotNil(t, a.Validate(), "Bearer token and bearer token source should not both be allowed")
+	a.BearerToken = nil
+
+	a.WithUserId("user").WithPassword("password")
+	assert.NotNil(t, a.Validate(), "Basic and bearer credentials should not both be allowed")
+}
+
 func TestConfig_WithHTTPClient(t *testing.T) {
 	tp := time.Second * 123
 	hc := http.Client{Timeout: tp}
@@ -71,7 +96,7 @@ func TestConfig_WithCACert(t *testing.T) {
 	a := c.WithCACert(cert)
 	assert.Nil(t, a.configErr, "Configuration error is not nil when providing a valid certificate")
 	transport := a.HTTPClient.Transport
-	assert.Equal(t, certPool, transport.(*http.Transport).TLSClientConfig.RootCAs, "Certificate not set to be trusted in HTTP Client")
+	assert.True(t, certPool.Equal(transport.(*http.Transport).TLSClientConfig.RootCAs), "Certificate not set to be trusted in HTTP Client")
 }
 
 func TestConfig_WithCAFilePath(t *testing.T) {
@@ -96,7 +121,7 @@ func TestConfig_WithCAFilePath(t *testing.T) {
 	a := c.WithCAFilePath(certOut.Name())
 	assert.Nil(t, a.configErr, "Configuration error is not nil when providing a valid certificate file")
 	transport := a.HTTPClient.Transport
-	assert.Equal(t, certPool, transport.(*http.Transport).TLSClientConfig.RootCAs, "Certificate not set to be trusted in HTTP Client")
+	assert.True(t, certPool.Equal(transport.(*http.Transport).TLSClientConfig.RootCAs), "Certificate not set to be trusted in HTTP Client")
 
 	invalidPEM, _ := ioutil.TempFile(os.TempDir(), "validcert")
 	defer os.Remove(invalidPEM.Name())
@@ -105,6 +130,75 @@ func TestConfig_WithCAFilePath(t *testing.T) {
 	assert.NotNil(t, a.configErr, "An invalid CA file did not create an error in the configuration")
 }
 
+func TestConfig_WithCAFilePaths(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca1 := newTestCA(t, "Test CA 1")
+	ca2 := newTestCA(t, "Test CA 2")
+	ca3 := newTestCA(t, "Test CA 3")
+	s1 := newTLSServer(t, ca1)
+	defer s1.Close()
+	s3 := newTLSServer(t, ca3)
+	defer s3.Close()
+	path1 := writePEM(t, dir, "ca1.pem", "CERTIFICATE", ca1.cert.Raw)
+	caDir := filepath.Join(dir, "cas")
+	os.Mkdir(caDir, 0700)
+	writePEM(t, caDir, "ca2.crt", "CERTIFICATE", ca2.cert.Raw)
+	writePEM(t, caDir, "ca3.pem", "CERTIFICATE", ca3.cert.Raw)
+	writePEM(t, caDir, "README", "NOTE", []byte("not a certificate"))
+
+	certPool := x509.NewCertPool()
+	for _, ca := range []*testCA{ca1, ca2, ca3} {
+		certPool.AddCert(ca.cert)
+	}
+	c := NewConfig().WithCAFilePaths(path1, caDir)
+	assert.Nil(t, c.configErr, "Configuration error is not nil when providing valid certificate files")
+	assert.True(t, certPool.Equal(c.tlsClientConfig().RootCAs), "Certificates in all the files and directory not trusted")
+	for _, s := range []*httptest.Server{s1, s3} {
+		c.WithEndPoint(s.URL)
+		assert.Nil(t, c.Validate())
+		_, err := sendField(c)
+		assert.Nil(t, err)
+	}
+
+	// A directory alone can be given
+	certPool = x509.NewCertPool()
+	certPool.AddCert(ca2.cert)
+	certPool.AddCert(ca3.cert)
+	c = NewConfig().WithCAFilePath(caDir)
+	assert.Nil(t, c.configErr)
+	assert.True(t, certPool.Equal(c.tlsClientConfig().RootCAs), "Certificates in the directory not trusted")
+
+	c = NewConfig().WithCAFilePaths(path1, filepath.Join(dir, "missing.pem"))
+	assert.NotNil(t, c.configErr, "A missing CA file did not create an error in the configuration")
+}
+
+func TestConfig_WithSystemCertPool(t *testing.T) {
+	sp, err := x509.SystemCertPool()
+	if err != nil {
+		t.Skipf("System certificate pool not available: %v", err)
+	}
+	ca := newTestCA(t, "Test CA")
+	sp.AddCert(ca.cert)
+	s := newTLSServer(t, ca)
+	defer s.Close()
+
+	// The order the options are given in does not matter
+	for _, c := range []*Config{
+		NewConfig().WithSystemCertPool().WithCACert(ca.cert),
+		NewConfig().WithCACert(ca.cert).WithSystemCertPool(),
+	} {
+		assert.True(t, c.TrustSystemCAs)
+		assert.True(t, sp.Equal(c.tlsClientConfig().RootCAs), "CA certificate not added to the system certificate pool")
+		c.WithEndPoint(s.URL)
+		_, err := sendField(c)
+		assert.Nil(t, err)
+	}
+
+	c := NewConfig().WithEndPoint("https://example.com").WithSystemCertPool()
+	assert.Nil(t, c.Validate(), "HTTPS endpoint using the system certificate pool should be valid")
+}
+
 func TestConfig_Validate(t *testing.T) {
 	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "Hello")
@@ -137,6 +231,16 @@ func TestConfig_Validate(t *testing.T) {
 		"EndPoint": "https://testurl",
 		"TrustCACert": "%s"
 	}`, certOut.Name())
+	validBearer := `{
+		"BearerToken": "token",
+		"EndPoint": "http://testurl"
+	}`
+	invalidBearerAndBasic := `{
+		"UserId": "testuser",
+		"Password": "password",
+		"BearerToken": "token",
+		"EndPoint": "http://testurl"
+	}`
 	invalidSimple := `{
 		"EndPoint": "ftp://testurl"
 	}`
@@ -160,6 +264,8 @@ func TestConfig_Validate(t *testing.T) {
 		{&validSimple, true},
 		{&validUserDetails, true},
 		{&validTLS, true},
+		{&validBearer, true},
+		{&invalidBearerAndBasic, false},
 		{&invalidNoEndpoint, false},
 		{&invalidSimple, false},
 		{&invalidUserDetails, false},
@@ -217,4 +323,52 @@ func TestConfig_Load(t *testing.T) {
 			assert.Nil(t, cfg.UserId, "UserId pointer should be nil")
 		}
 	}
+
+	testConfigFile, _ := ioutil.TempFile(os.TempDir(), "config")
+	defer os.Remove(testConfigFile.Name())
+	testConfigFile.WriteString(`{
+		"BearerToken": "token",
+		"EndPoint": "http://testurl"
+	}`)
+	cfg := Load(testConfigFile.Name())
+	assert.Equal(t, "token", *cfg.BearerToken, "BearerToken not set on config correctly")
+}
+
+func TestConfig_Load_TrustCACert(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca1 := newTestCA(t, "Test CA 1")
+	ca2 := newTestCA(t, "Test CA 2")
+	path1 := writePEM(t, dir, "ca1.pem", "CERTIFICATE", ca1.cert.Raw)
+	path2 := writePEM(t, dir, "ca2.pem", "CERTIFICATE", ca2.cert.Raw)
+	certPool := x509.NewCertPool()
+	certPool.AddCert(ca1.cert)
+	certPool.AddCert(ca2.cert)
+
+	var tests = []struct {
+		cfgJson string
+		pool    *x509.CertPool
+	}{
+		{fmt.Sprintf(`{"EndPoint": "https://testurl", "TrustCACert": %q}`, path1), nil},
+		{fmt.Sprintf(`{"EndPoint": "https://testurl", "TrustCACert": [%q, %q]}`, path1, path2), certPool},
+		{fmt.Sprintf(`{"EndPoint": "https://testurl", "TrustCACerts": [%q, %q]}`, path1, path2), certPool},
+	}
+	for _, test := range tests {
+		testConfigFile := filepath.Join(dir, "config.json")
+		ioutil.WriteFile(testConfigFile, []byte(test.cfgJson), 0600)
+		cfg := Load(testConfigFile)
+		assert.Nil(t, cfg.Validate(), "Configuration not valid: %s", test.cfgJson)
+		if test.pool == nil {
+			assert.Equal(t, path1, *cfg.TrustCACert)
+			test.pool = x509.NewCertPool()
+			test.pool.AddCert(ca1.cert)
+		} else {
+			assert.Equal(t, []string{path1, path2}, cfg.TrustCACerts)
+		}
+		assert.True(t, test.pool.Equal(cfg.tlsClientConfig().RootCAs), "CA certificates not trusted: %s", test.cfgJson)
+	}
+
+	testConfigFile := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(testConfigFile, []byte(`{"EndPoint": "https://testurl", "TrustCACert": 1}`), 0600)
+	assert.NotNil(t, Load(testConfigFile).Validate(), "TrustCACert that is not a path should be invalid")
 }
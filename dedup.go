@@ -0,0 +1,125 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// A flightGroup shares the result of identical GET requests that are in flight at the same time.
+type flightGroup struct {
+	headers []string
+	mu      sync.Mutex
+	calls   map[string]*flightCall
+}
+
+// A flightCall is a request in flight that other identical requests are waiting on.
+type flightCall struct {
+	done         chan struct{}
+	result       attemptResult
+	hedgeAttempt int
+	// The number of Requests waiting on the call, it is cancelled when they have all gone
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Deduplicate identical GET requests sent using this config while they are in flight.
+// Requests are identical if they have the same method, full URL and values for the headers named.
+// Only one call is made to the ReST service and each Request receives a copy of the response, decoded into its own response target.
+// The call is only cancelled once all of the identical Requests waiting on it have been cancelled.
+func (c *Config) WithDeduplication(headers ...string) *Config {
+	// Conditional request headers are always part of the key so that a 304 Not Modified response is only shared with requests that asked for it
+	h := []string{"If-None-Match", "If-Modified-Since"}
+	for _, n := range headers {
+		h = append(h, http.CanonicalHeaderKey(n))
+	}
+	c.flights = &flightGroup{
+		headers: h,
+		calls:   make(map[string]*flightCall),
+	}
+	return c
+}
+
+// key returns the key identifying identical requests.
+func (g *flightGroup) key(req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteString(" ")
+	b.WriteString(req.URL.String())
+	for _, h := range g.headers {
+		b.WriteString("\n")
+		b.WriteString(h)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(req.Header.Values(h), ", "))
+	}
+	return b.String()
+}
+
+// do sends the Request unless an identical one is already in flight, in which case the Request receives a copy of its result.
+func (g *flightGroup) do(ctx context.Context, r *Request) error {
+	key := g.key(r.HTTPRequest)
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if !ok {
+		// The call is made separately from any one Request so cancelling the first does not fail the others
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+		call := *r
+		go g.call(callCtx, key, c, &call)
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		g.mu.Lock()
+		if c.waiters--; c.waiters == 0 {
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+	r.setResult(c.result.copy())
+	r.HedgeAttempt = c.hedgeAttempt
+	return c.result.err
+}
+
+// call makes the call to the ReST service for the Requests waiting on it.
+func (g *flightGroup) call(ctx context.Context, key string, c *flightCall, r *Request) {
+	defer c.cancel()
+	err := r.networkRoundTrip(ctx)
+	c.result = attemptResult{
+		response: r.HTTPResponse,
+		body:     r.ResponseBody,
+		err:      err,
+	}
+	c.hedgeAttempt = r.HedgeAttempt
+	g.mu.Lock()
+	g.forget(key, c)
+	g.mu.Unlock()
+	close(c.done)
+}
+
+// forget stops later requests joining the call. The lock must be held.
+func (g *flightGroup) forget(key string, c *flightCall) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
+
+// copy returns a copy of the result that can be modified without affecting the original.
+func (res attemptResult) copy() attemptResult {
+	if res.response != nil {
+		resp := *res.response
+		resp.Header = res.response.Header.Clone()
+		res.response = &resp
+	}
+	if res.body != nil {
+		res.body = append([]byte(nil), res.body...)
+	}
+	return res
+}
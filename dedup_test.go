@@ -0,0 +1,131 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConfig_WithDeduplication(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Header().Set("X-Accept", r.Header.Get("Accept"))
+		fmt.Fprintln(w, `{"token": "abc", "items": [1, 2]}`)
+	}))
+	defer s.Close()
+
+	type tokenData struct {
+		Token string `json:"token"`
+		Items []int  `json:"items"`
+	}
+	c := NewConfig().WithEndPoint(s.URL).WithDeduplication("accept")
+	send := func(accept string, n int) ([]tokenData, []*Request) {
+		data := make([]tokenData, n)
+		reqs := make([]*Request, n)
+		var wg sync.WaitGroup
+		for i := range data {
+			r, _ := BuildRequest(c, NewGetOperation().WithPath("/token").WithHeader("Accept", accept).WithResponseTarget(&data[i]))
+			reqs[i] = r
+			wg.Add(1)
+			go func(r *Request) {
+				defer wg.Done()
+				code, err := SendContext(context.Background(), r)
+				assert.Nil(t, err, "Error sending deduplicated request")
+				assert.Equal(t, http.StatusOK, *code)
+			}(r)
+		}
+		// Give all the requests time to be in flight before responding
+		time.Sleep(time.Millisecond * 50)
+		release <- struct{}{}
+		wg.Wait()
+		return data, reqs
+	}
+
+	data, reqs := send("application/json", 5)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Identical requests should share one call")
+	for i := range data {
+		assert.Equal(t, "abc", data[i].Token, "Response not decoded into target %d", i)
+		assert.Equal(t, "application/json", reqs[i].HTTPResponse.Header.Get("X-Accept"))
+	}
+	data[0].Items[0] = 99
+	reqs[0].ResponseBody[0] = 'x'
+	reqs[0].HTTPResponse.Header.Set("X-Accept", "modified")
+	assert.Equal(t, 1, data[1].Items[0], "Decoded responses should not be shared")
+	assert.Equal(t, byte('{'), reqs[1].ResponseBody[0], "Response bodies should not be shared")
+	assert.Equal(t, "application/json", reqs[1].HTTPResponse.Header.Get("X-Accept"), "Response headers should not be shared")
+
+	// Requests that differ in a selected header are not deduplicated
+	atomic.StoreInt32(&calls, 0)
+	done := make(chan struct{})
+	go func() {
+		send("text/plain", 1)
+		close(done)
+	}()
+	send("application/json", 1)
+	<-done
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "Requests with different headers should not be deduplicated")
+}
+
+func TestConfig_WithDeduplication_Cancel(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	cancelled := make(chan struct{}, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+			fmt.Fprintln(w, `{"token": "abc"}`)
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL).WithDeduplication()
+
+	// Cancelling the first request does not fail the others waiting on the call
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	r1, _ := BuildRequest(c, NewGetOperation())
+	err1 := make(chan error)
+	go func() {
+		_, err := SendContext(ctx1, r1)
+		err1 <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	r2, _ := BuildRequest(c, NewGetOperation())
+	err2 := make(chan error)
+	go func() {
+		_, err := SendContext(context.Background(), r2)
+		err2 <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	cancel1()
+	assert.Equal(t, context.Canceled, <-err1, "Cancelled request should return its context's error")
+	release <- struct{}{}
+	assert.Nil(t, <-err2, "Request should not fail when another identical request is cancelled")
+	assert.Equal(t, http.StatusOK, r2.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Identical requests should share one call")
+
+	// The call is cancelled once every request waiting on it has been
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	r3, _ := BuildRequest(c, NewGetOperation())
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel3()
+	}()
+	_, err := SendContext(ctx3, r3)
+	assert.Equal(t, context.Canceled, err)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Call not cancelled when all requests waiting on it were cancelled")
+	}
+}
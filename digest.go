@@ -0,0 +1,211 @@
+package restclient
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Digest algorithms supported, strongest first.
+var digestAlgorithms = []string{"SHA-256-sess", "SHA-256", "MD5-sess", "MD5"}
+
+// A DigestAuthenticator authenticates requests using RFC 7616 HTTP Digest authentication, reusing the ReST service's nonce for later requests.
+type DigestAuthenticator struct {
+	userId    string
+	password  string
+	mu        sync.Mutex
+	challenge *digestChallenge
+	nc        uint32
+	// cnonce generates client nonces, it is replaced in tests
+	cnonce func() (string, error)
+}
+
+// A digestChallenge holds the parameters of a Digest WWW-Authenticate challenge.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+}
+
+// Create a new DigestAuthenticator for the user ID and password given.
+func NewDigestAuthenticator(userId, password string) *DigestAuthenticator {
+	return &DigestAuthenticator{
+		userId:   userId,
+		password: password,
+		cnonce:   digestCNonce,
+	}
+}
+
+// Authenticate sets the Digest authorization header on the request if a challenge has been received from the ReST service.
+func (a *DigestAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	ch := a.challenge
+	if ch == nil {
+		a.mu.Unlock()
+		return nil
+	}
+	a.nc++
+	nc := a.nc
+	a.mu.Unlock()
+
+	h := ch.hash()
+	cnonce, err := a.cnonce()
+	if err != nil {
+		return err
+	}
+	ha1 := digestHash(h, a.userId+":"+ch.realm+":"+a.password)
+	if strings.HasSuffix(ch.algorithm, "-sess") {
+		ha1 = digestHash(h, ha1+":"+ch.nonce+":"+cnonce)
+	}
+	uri := req.URL.RequestURI()
+	a2 := req.Method + ":" + uri
+	if ch.qop == "auth-int" {
+		body, err := requestBody(req)
+		if err != nil {
+			return fmt.Errorf("Request body could not be read for Digest authentication; %v", err)
+		}
+		a2 += ":" + digestHash(h, string(body))
+	}
+	ha2 := digestHash(h, a2)
+	ncs := fmt.Sprintf("%08x", nc)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, uri=%q, algorithm=%s, nonce=%q`, a.userId, ch.realm, uri, ch.algorithm, ch.nonce)
+	if ch.qop != "" {
+		fmt.Fprintf(&b, `, nc=%s, cnonce=%q, qop=%s, response=%q`, ncs, cnonce, ch.qop, digestHash(h, ha1+":"+ch.nonce+":"+ncs+":"+cnonce+":"+ch.qop+":"+ha2))
+	} else {
+		// RFC 2069 compatibility
+		fmt.Fprintf(&b, `, response=%q`, digestHash(h, ha1+":"+ch.nonce+":"+ha2))
+	}
+	if ch.opaque != "" {
+		fmt.Fprintf(&b, `, opaque=%q`, ch.opaque)
+	}
+	req.Header.Set("Authorization", b.String())
+	return nil
+}
+
+// HandleChallenge remembers the Digest challenge and asks for the request to be sent again, unless the credentials were rejected for the nonce.
+func (a *DigestAuthenticator) HandleChallenge(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	ch, stale := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if ch == nil {
+		return false
+	}
+	if resp.Request != nil && !stale {
+		if params, ok := authParams(resp.Request.Header.Get("Authorization"), "Digest"); ok && params["nonce"] == ch.nonce {
+			return false
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Another request may already have started using this nonce
+	if a.challenge == nil || a.challenge.nonce != ch.nonce {
+		a.challenge = ch
+		a.nc = 0
+	}
+	return true
+}
+
+// hash returns the hash function of the challenge's algorithm.
+func (ch *digestChallenge) hash() func() hash.Hash {
+	if strings.HasPrefix(ch.algorithm, "SHA-256") {
+		return sha256.New
+	}
+	return md5.New
+}
+
+// parseDigestChallenge returns the supported Digest challenge with the strongest algorithm, or nil, and whether the previous nonce was stale.
+func parseDigestChallenge(values []string) (*digestChallenge, bool) {
+	var best *digestChallenge
+	var stale bool
+	rank := func(alg string) int {
+		for i, a := range digestAlgorithms {
+			if a == alg {
+				return len(digestAlgorithms) - i
+			}
+		}
+		return 0
+	}
+	for _, c := range parseChallenges(values) {
+		if !strings.EqualFold(c.scheme, "Digest") || c.params["nonce"] == "" {
+			continue
+		}
+		alg := c.params["algorithm"]
+		if alg == "" {
+			alg = "MD5"
+		}
+		for _, a := range digestAlgorithms {
+			if strings.EqualFold(a, alg) {
+				alg = a
+			}
+		}
+		if rank(alg) == 0 || (best != nil && rank(alg) <= rank(best.algorithm)) {
+			continue
+		}
+		ch := &digestChallenge{
+			realm:     c.params["realm"],
+			nonce:     c.params["nonce"],
+			opaque:    c.params["opaque"],
+			algorithm: alg,
+		}
+		if qop := c.params["qop"]; qop != "" {
+			ch.qop = "auth-int"
+			for _, q := range strings.Split(qop, ",") {
+				if strings.TrimSpace(q) == "auth" {
+					ch.qop = "auth"
+				}
+			}
+			if !strings.Contains(qop, ch.qop) {
+				continue
+			}
+		}
+		best = ch
+		stale = strings.EqualFold(c.params["stale"], "true")
+	}
+	return best, stale
+}
+
+// requestBody returns a copy of the body of the request, leaving the request's body unread.
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("Request body cannot be read more than once")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// digestHash returns the lower case hex encoded hash of the value.
+func digestHash(h func() hash.Hash, v string) string {
+	d := h()
+	io.WriteString(d, v)
+	return hex.EncodeToString(d.Sum(nil))
+}
+
+// digestCNonce returns a random client nonce.
+func digestCNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Digest client nonce could not be generated; %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
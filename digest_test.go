@@ -0,0 +1,166 @@
+package restclient
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDigestAuthenticator_RFC7616Example(t *testing.T) {
+	// Example from RFC 7616 section 3.9.1
+	var tests = []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+	for _, test := range tests {
+		a := NewDigestAuthenticator("Mufasa", "Circle of Life")
+		a.cnonce = func() (string, error) {
+			return "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", nil
+		}
+		resp := &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Header: http.Header{"Www-Authenticate": {fmt.Sprintf(`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=%s, `+
+				`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`, test.algorithm)}},
+		}
+		assert.True(t, a.HandleChallenge(resp), "Challenge not handled")
+		req, _ := http.NewRequest("GET", "http://www.example.org/dir/index.html", nil)
+		a.Authenticate(context.Background(), req)
+		params, ok := authParams(req.Header.Get("Authorization"), "Digest")
+		assert.True(t, ok)
+		assert.Equal(t, test.response, params["response"], "Response not as expected for %s", test.algorithm)
+		assert.Equal(t, "00000001", params["nc"])
+		assert.Equal(t, "auth", params["qop"])
+		assert.Equal(t, "/dir/index.html", params["uri"])
+		assert.Equal(t, "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", params["opaque"])
+		assert.Equal(t, test.algorithm, params["algorithm"])
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	ch, stale := parseDigestChallenge([]string{
+		`Digest realm="r", nonce="n1", algorithm=MD5, qop="auth"`,
+		`Basic realm="r", Digest realm="r", nonce="n2", algorithm=SHA-256, qop="auth-int", stale=TRUE`,
+	})
+	assert.Equal(t, "SHA-256", ch.algorithm, "Strongest algorithm should be chosen")
+	assert.Equal(t, "n2", ch.nonce)
+	assert.Equal(t, "auth-int", ch.qop)
+	assert.True(t, stale)
+
+	ch, _ = parseDigestChallenge([]string{`Digest realm="r", nonce="n", algorithm=SHA-512-256`})
+	assert.Nil(t, ch, "Unsupported algorithm should be ignored")
+	ch, _ = parseDigestChallenge([]string{`Digest realm="r", nonce="n"`})
+	assert.Equal(t, "MD5", ch.algorithm, "MD5 is the default algorithm")
+	assert.Equal(t, "", ch.qop)
+}
+
+// digestServer checks Digest credentials, issuing a new nonce every maxUses requests.
+type digestServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	algorithm string
+	qop       string
+	nonce     int
+	uses      int
+	maxUses   int
+	ncs       []string
+	bodies    []string
+	calls     int
+}
+
+func newDigestServer(algorithm, qop string) *digestServer {
+	s := &digestServer{algorithm: algorithm, qop: qop, nonce: 1, maxUses: 100}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		nonce := fmt.Sprintf("nonce%d", s.nonce)
+		params, ok := authParams(r.Header.Get("Authorization"), "Digest")
+		if !ok || params["nonce"] != nonce || !s.valid(r, params, body) {
+			stale := ok && params["nonce"] != nonce
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", qop="%s", algorithm=%s, nonce="%s", opaque="op", stale=%t`, s.qop, s.algorithm, nonce, stale))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.ncs = append(s.ncs, params["nc"])
+		s.bodies = append(s.bodies, string(body))
+		s.uses++
+		if s.uses >= s.maxUses {
+			s.nonce++
+			s.uses = 0
+		}
+		fmt.Fprint(w, `{"ok": true}`)
+	}))
+	return s
+}
+
+func (s *digestServer) valid(r *http.Request, params map[string]string, body []byte) bool {
+	h := md5.New
+	if s.algorithm == "SHA-256" {
+		h = sha256.New
+	}
+	hex := func(h func() hash.Hash, v string) string {
+		d := h()
+		d.Write([]byte(v))
+		return fmt.Sprintf("%x", d.Sum(nil))
+	}
+	ha1 := hex(h, "user:test:pa55word")
+	a2 := r.Method + ":" + params["uri"]
+	if params["qop"] == "auth-int" {
+		a2 += ":" + hex(h, string(body))
+	}
+	expected := hex(h, strings.Join([]string{ha1, params["nonce"], params["nc"], params["cnonce"], params["qop"], hex(h, a2)}, ":"))
+	return params["response"] == expected && params["opaque"] == "op" && params["uri"] == r.URL.RequestURI()
+}
+
+func TestSend_Digest(t *testing.T) {
+	var tests = []struct {
+		algorithm string
+		qop       string
+	}{
+		{"MD5", "auth"},
+		{"SHA-256", "auth"},
+		{"MD5", "auth-int"},
+		{"SHA-256", "auth-int"},
+	}
+	for _, test := range tests {
+		s := newDigestServer(test.algorithm, test.qop)
+		s.maxUses = 3
+		c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(NewDigestAuthenticator("user", "pa55word"))
+		for i := 0; i < 4; i++ {
+			r, _ := BuildRequest(c, NewPostOperation().WithPath("/a?b=c").WithBodyDataString(fmt.Sprintf(`{"n": %d}`, i)))
+			code, err := Send(r)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, *code, "Request not authenticated with %s %s", test.algorithm, test.qop)
+		}
+		// The first request is challenged, the next two reuse the nonce, then the nonce goes stale
+		assert.Equal(t, 6, s.calls, "Unexpected number of calls with %s %s", test.algorithm, test.qop)
+		assert.Equal(t, []string{"00000001", "00000002", "00000003", "00000001"}, s.ncs, "Nonce counts not tracked")
+		assert.Equal(t, `{"n": 0}`, s.bodies[0], "Body not replayed after the challenge")
+		assert.Equal(t, `{"n": 3}`, s.bodies[3], "Body not replayed after the stale nonce")
+		s.Close()
+	}
+
+	// Wrong credentials are not retried more than once
+	s := newDigestServer("MD5", "auth")
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(NewDigestAuthenticator("user", "wrong"))
+	r, _ := BuildRequest(c, NewGetOperation())
+	code, _ := Send(r)
+	assert.Equal(t, http.StatusUnauthorized, *code)
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusUnauthorized, *code)
+	assert.Equal(t, 3, s.calls, "Rejected credentials for the current nonce should not be retried")
+}
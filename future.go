@@ -0,0 +1,95 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"reflect"
+)
+
+// A Future is the pending result of a Request sent with SendAsync.
+type Future struct {
+	request    *Request
+	done       chan struct{}
+	statusCode *int
+	err        error
+}
+
+// Send the request to the ReST service in a new goroutine, returning a Future for its result.
+// The Request must not be used until the Future is done.
+func SendAsync(ctx context.Context, r *Request) *Future {
+	f := &Future{
+		request: r,
+		done:    make(chan struct{}),
+	}
+	go func() {
+		f.statusCode, f.err = SendContext(ctx, r)
+		close(f.done)
+	}()
+	return f
+}
+
+// Done returns a channel that is closed when the Request has completed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait for the Request to complete and return its result, as returned by Send.
+func (f *Future) Wait() (httpCode *int, err error) {
+	<-f.done
+	return f.statusCode, f.err
+}
+
+// Request returns the Request the Future is for.
+func (f *Future) Request() *Request {
+	return f.request
+}
+
+// StatusCode waits for the Request to complete and returns the HTTP status code.
+func (f *Future) StatusCode() int {
+	<-f.done
+	return *f.statusCode
+}
+
+// Err waits for the Request to complete and returns any error from sending it.
+func (f *Future) Err() error {
+	<-f.done
+	return f.err
+}
+
+// Wait for all of the Futures to complete.
+// The error returned aggregates the errors of the Futures using go-multierror and is nil if none failed.
+// If the context is done before all of the Futures complete its error is returned.
+func WaitAll(ctx context.Context, fs ...*Future) error {
+	var errs *multierror.Error
+	for i, f := range fs {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if f.err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("Request %d: %v", i, f.err))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Wait for any one of the Futures to complete, returning the index of the first to do so.
+// If the context is done first, or no Futures are given, -1 is returned with an error.
+func WaitAny(ctx context.Context, fs ...*Future) (int, error) {
+	if len(fs) == 0 {
+		return -1, errors.New("No futures to wait for")
+	}
+	cases := make([]reflect.SelectCase, len(fs)+1)
+	for i, f := range fs {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(f.done)}
+	}
+	cases[len(fs)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	i, _, _ := reflect.Select(cases)
+	if i == len(fs) {
+		return -1, ctx.Err()
+	}
+	return i, nil
+}
@@ -0,0 +1,122 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func delayServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := time.ParseDuration(r.URL.Query().Get("delay"))
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+			return
+		}
+		fmt.Fprintf(w, `{"delay": %q}`, d)
+	}))
+}
+
+func delayRequest(t *testing.T, c *Config, d string, target interface{}) *Request {
+	o := NewGetOperation().WithQueryDataString("delay=" + d)
+	if target != nil {
+		o.WithResponseTarget(target)
+	}
+	r, err := BuildRequest(c, o)
+	if err != nil {
+		t.Fatalf("Error building request: %v", err)
+	}
+	return r
+}
+
+func TestSendAsync(t *testing.T) {
+	s := delayServer()
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+
+	var d struct {
+		Delay string `json:"delay"`
+	}
+	f := SendAsync(context.Background(), delayRequest(t, c, "10ms", &d))
+	select {
+	case <-f.Done():
+		t.Error("Future should not be done before the response is received")
+	default:
+	}
+	code, err := f.Wait()
+	assert.Nil(t, err, "Error sending request")
+	assert.Equal(t, http.StatusOK, *code, "Status code not as expected")
+	assert.Equal(t, http.StatusOK, f.StatusCode(), "Status code not as expected")
+	assert.Nil(t, f.Err())
+	assert.Equal(t, "10ms", d.Delay, "Response not decoded into target")
+	assert.Equal(t, http.StatusOK, f.Request().StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	f = SendAsync(ctx, delayRequest(t, c, "1s", nil))
+	code, err = f.Wait()
+	assert.NotNil(t, err, "Expected an error when the context times out")
+	assert.Equal(t, http.StatusServiceUnavailable, *code)
+}
+
+func TestWaitAll(t *testing.T) {
+	s := delayServer()
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+
+	var fs []*Future
+	for _, d := range []string{"30ms", "10ms", "20ms"} {
+		fs = append(fs, SendAsync(context.Background(), delayRequest(t, c, d, nil)))
+	}
+	assert.Nil(t, WaitAll(context.Background(), fs...), "Error waiting for all futures")
+	for _, f := range fs {
+		assert.Equal(t, http.StatusOK, f.StatusCode())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	fs = []*Future{
+		SendAsync(ctx, delayRequest(t, c, "0s", nil)),
+		SendAsync(ctx, delayRequest(t, c, "1s", nil)),
+	}
+	err := WaitAll(context.Background(), fs...)
+	assert.IsType(t, &multierror.Error{}, err, "Expected aggregated errors")
+	assert.Len(t, err.(*multierror.Error).Errors, 1, "Expected only the timed out request to fail")
+
+	f := SendAsync(context.Background(), delayRequest(t, c, "1s", nil))
+	err = WaitAll(ctx, f)
+	assert.Equal(t, context.DeadlineExceeded, err, "Expected the context error")
+}
+
+func TestWaitAny(t *testing.T) {
+	s := delayServer()
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs := []*Future{
+		SendAsync(ctx, delayRequest(t, c, "1s", nil)),
+		SendAsync(ctx, delayRequest(t, c, "10ms", nil)),
+		SendAsync(ctx, delayRequest(t, c, "1s", nil)),
+	}
+	i, err := WaitAny(context.Background(), fs...)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, i, "Expected the fastest request to complete first")
+
+	wctx, wcancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer wcancel()
+	i, err = WaitAny(wctx, fs[0], fs[2])
+	assert.Equal(t, -1, i)
+	assert.Equal(t, context.DeadlineExceeded, err, "Expected the context error")
+
+	i, err = WaitAny(context.Background())
+	assert.Equal(t, -1, i)
+	assert.NotNil(t, err, "Expected an error with no futures")
+}
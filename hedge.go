@@ -0,0 +1,158 @@
+package restclient
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Default total number of copies of a request a HedgePolicy sends, including the original.
+	DefaultHedgeMaxAttempts = 2
+	// Default number of latencies a HedgePolicy must observe before the percentile is used for the delay.
+	DefaultHedgeMinSamples = 20
+	// Number of recent latencies a HedgePolicy keeps to calculate the percentile from.
+	hedgeSampleSize = 100
+)
+
+// A HedgePolicy sends additional copies of a GET request to the ReST service if a response has not been received after a delay.
+// The first successful response is used and the other copies are cancelled.
+// A copy fails if it cannot be sent or the ReST service responds with a 5xx status, in which case the next copy is sent straight away.
+//
+// The delay can be fixed or can be a percentile of the latency of previous requests sent with the policy.
+// A HedgePolicy is safe to share between requests and should be shared so that latencies are observed across them.
+type HedgePolicy struct {
+	delay       time.Duration
+	maxAttempts int
+	percentile  float64
+	minSamples  int
+	mu          sync.Mutex
+	latencies   []time.Duration
+	next        int
+}
+
+// Create a new HedgePolicy that sends up to maxAttempts copies of a request in total, each after the delay given.
+func NewHedgePolicy(delay time.Duration, maxAttempts int) *HedgePolicy {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultHedgeMaxAttempts
+	}
+	return &HedgePolicy{
+		delay:       delay,
+		maxAttempts: maxAttempts,
+		minSamples:  DefaultHedgeMinSamples,
+	}
+}
+
+// Use a percentile, between 0 and 100, of the latencies observed by the policy as the delay.
+// The fixed delay is used until minSamples latencies have been observed.
+func (p *HedgePolicy) WithPercentile(percentile float64, minSamples int) *HedgePolicy {
+	p.percentile = percentile
+	if minSamples > 0 {
+		p.minSamples = minSamples
+	}
+	return p
+}
+
+// Delay returns the time to wait before sending another copy of a request.
+func (p *HedgePolicy) Delay() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.percentile <= 0 || len(p.latencies) < p.minSamples {
+		return p.delay
+	}
+	l := append([]time.Duration(nil), p.latencies...)
+	sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+	i := int(math.Ceil(p.percentile/100*float64(len(l)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(l) {
+		i = len(l) - 1
+	}
+	return l[i]
+}
+
+// observe records the latency of a successful request.
+func (p *HedgePolicy) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.latencies) < hedgeSampleSize {
+		p.latencies = append(p.latencies, d)
+		return
+	}
+	p.latencies[p.next] = d
+	p.next = (p.next + 1) % hedgeSampleSize
+}
+
+// Hedge GET requests sent using this config.
+func (c *Config) WithHedging(p *HedgePolicy) *Config {
+	c.hedgePolicy = p
+	return c
+}
+
+// Hedge this Operation, overriding any HedgePolicy on the Config. Only GET Operations are hedged.
+func (o *Operation) WithHedging(p *HedgePolicy) *Operation {
+	o.hedgePolicy = p
+	return o
+}
+
+// hedgePolicy returns the HedgePolicy to use for the Request, or nil if it should not be hedged.
+func (r *Request) hedgePolicy() *HedgePolicy {
+	if r.HTTPRequest.Method != "GET" {
+		return nil
+	}
+	if r.Operation.hedgePolicy != nil {
+		return r.Operation.hedgePolicy
+	}
+	return r.Config.hedgePolicy
+}
+
+// hedgedRoundTrip sends copies of the HTTP request according to the HedgePolicy and records the first successful response.
+// If every copy fails the result of the last to complete is recorded.
+func (r *Request) hedgedRoundTrip(ctx context.Context, p *HedgePolicy) error {
+	ctx, cancel := context.WithCancel(ctx)
+	// Cancel the copies that did not win
+	defer cancel()
+
+	results := make(chan attemptResult, p.maxAttempts)
+	sent := 0
+	send := func() {
+		sent++
+		go func(n int) {
+			results <- r.attempt(ctx, n)
+		}(sent)
+	}
+	send()
+	delay := p.Delay()
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	var last attemptResult
+	for received := 0; received < sent; {
+		select {
+		case res := <-results:
+			received++
+			if res.err == nil && res.response.StatusCode < http.StatusInternalServerError {
+				p.observe(res.latency)
+				r.setResult(res)
+				r.HedgeAttempt = res.attempt
+				return nil
+			}
+			last = res
+			if sent < p.maxAttempts {
+				send()
+				t.Reset(delay)
+			}
+		case <-t.C:
+			if sent < p.maxAttempts {
+				send()
+				t.Reset(delay)
+			}
+		}
+	}
+	r.setResult(last)
+	r.HedgeAttempt = last.attempt
+	return last.err
+}
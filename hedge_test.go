@@ -0,0 +1,106 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hedgeServer delays or fails the responses to the first calls it receives, as defined by the behaviour function.
+func hedgeServer(calls, cancelled *int32, behaviour func(n int32) (time.Duration, int)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		d, code := behaviour(n)
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+			atomic.AddInt32(cancelled, 1)
+			return
+		}
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"call": %d}`, n)
+	}))
+}
+
+func TestHedgePolicy_Send(t *testing.T) {
+	var calls, cancelled int32
+	s := hedgeServer(&calls, &cancelled, func(n int32) (time.Duration, int) {
+		if n == 1 {
+			return time.Second, http.StatusOK
+		}
+		return 0, http.StatusOK
+	})
+	defer s.Close()
+
+	var d struct {
+		Call int `json:"call"`
+	}
+	c := NewConfig().WithEndPoint(s.URL).WithHedging(NewHedgePolicy(time.Millisecond*20, 3))
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	start := time.Now()
+	code, err := Send(r)
+	assert.Nil(t, err, "Error sending hedged request")
+	assert.Equal(t, http.StatusOK, *code)
+	assert.True(t, time.Since(start) < time.Millisecond*500, "Hedged request was not sent")
+	assert.Equal(t, 2, r.HedgeAttempt, "Expected the second attempt to win")
+	assert.Equal(t, 2, d.Call, "Response of the winning attempt not decoded")
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "Only two attempts should have been sent")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cancelled), "Losing attempt not cancelled")
+
+	// POST operations are not hedged
+	atomic.StoreInt32(&calls, 0)
+	r, _ = BuildRequest(c, NewPostOperation())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = SendContext(ctx, r)
+	assert.NotNil(t, err, "POST should not have been hedged")
+	assert.Equal(t, 0, r.HedgeAttempt)
+}
+
+func TestHedgePolicy_Failures(t *testing.T) {
+	var calls, cancelled int32
+	s := hedgeServer(&calls, &cancelled, func(n int32) (time.Duration, int) {
+		if n < 3 {
+			return 0, http.StatusServiceUnavailable
+		}
+		return 0, http.StatusOK
+	})
+	defer s.Close()
+
+	// Failed attempts are followed immediately by the next so the long delay is not waited for
+	p := NewHedgePolicy(time.Minute, 3)
+	r, _ := BuildRequest(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithHedging(p))
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, 3, r.HedgeAttempt, "Expected the third attempt to win")
+
+	atomic.StoreInt32(&calls, 0)
+	r, _ = BuildRequest(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithHedging(NewHedgePolicy(time.Minute, 2)))
+	code, err = Send(r)
+	assert.Equal(t, http.StatusServiceUnavailable, *code, "Expected the status of the last failed attempt")
+	assert.Equal(t, 2, r.HedgeAttempt)
+}
+
+func TestHedgePolicy_Delay(t *testing.T) {
+	p := NewHedgePolicy(time.Second, 0)
+	assert.Equal(t, DefaultHedgeMaxAttempts, p.maxAttempts)
+	p.WithPercentile(90, 10)
+	for i := 1; i <= 9; i++ {
+		p.observe(time.Millisecond * time.Duration(i))
+	}
+	assert.Equal(t, time.Second, p.Delay(), "Fixed delay should be used until enough samples are observed")
+	p.observe(time.Millisecond * 10)
+	assert.Equal(t, time.Millisecond*9, p.Delay(), "Percentile delay not as expected")
+	for i := 0; i < hedgeSampleSize; i++ {
+		p.observe(time.Millisecond)
+	}
+	assert.Len(t, p.latencies, hedgeSampleSize, "Latency samples should be bounded")
+	assert.Equal(t, time.Millisecond, p.Delay())
+}
@@ -0,0 +1,230 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the header holding the signature of a request signed by an HMACSigner.
+	HMACSignatureHeader = "Signature"
+	// Components signed by an HMACSigner in addition to any headers selected.
+	HMACDefaultSignedComponents = "(request-target) (created) host digest"
+	// How old a signature may be when verified, to allow for clock differences.
+	HMACSignatureMaxAge = 5 * time.Minute
+)
+
+// A contentDigester is an Authenticator that signs a digest of the request body, which is added when the request is built.
+type contentDigester interface {
+	contentDigest()
+}
+
+// setContentDigest sets the Digest header of RFC 3230 and the Content-Digest header of RFC 9530 to the SHA-256 hash of the body.
+func setContentDigest(h http.Header, body []byte) {
+	d := sha256.Sum256(body)
+	b := base64.StdEncoding.EncodeToString(d[:])
+	h.Set("Digest", "SHA-256="+b)
+	h.Set("Content-Digest", "sha-256=:"+b+":")
+}
+
+// An HMACSigner signs requests with a rotatable shared secret in the draft-cavage-http-signatures Signature header format.
+type HMACSigner struct {
+	mu         sync.RWMutex
+	keys       map[string][]byte
+	keyID      string
+	components []string
+	now        func() time.Time
+}
+
+// Create a new HMACSigner that signs with the secret key given.
+func NewHMACSigner(keyID string, secret []byte) *HMACSigner {
+	s := &HMACSigner{
+		keys:       make(map[string][]byte),
+		components: strings.Fields(HMACDefaultSignedComponents),
+		now:        time.Now,
+	}
+	return s.WithKey(keyID, secret)
+}
+
+// Add a key to the signer and sign with it from now on. Keys added before remain available for verifying signatures.
+func (s *HMACSigner) WithKey(keyID string, secret []byte) *HMACSigner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[keyID] = append([]byte(nil), secret...)
+	s.keyID = keyID
+	return s
+}
+
+// Sign with the key previously added with the key ID given.
+func (s *HMACSigner) UseKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[keyID]; !ok {
+		return fmt.Errorf("HMAC key %s not known", keyID)
+	}
+	s.keyID = keyID
+	return nil
+}
+
+// Remove a key that is no longer valid. The key being used for signing cannot be removed.
+func (s *HMACSigner) RemoveKey(keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if keyID == s.keyID {
+		return fmt.Errorf("HMAC key %s is being used for signing", keyID)
+	}
+	delete(s.keys, keyID)
+	return nil
+}
+
+// Sign the headers given, in addition to the default components.
+func (s *HMACSigner) WithSignedHeaders(headers ...string) *HMACSigner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range headers {
+		s.components = append(s.components, strings.ToLower(h))
+	}
+	return s
+}
+
+func (s *HMACSigner) contentDigest() {}
+
+// Authenticate signs the request and sets the Signature header.
+func (s *HMACSigner) Authenticate(ctx context.Context, req *http.Request) error {
+	s.mu.RLock()
+	keyID := s.keyID
+	key := s.keys[keyID]
+	components := s.components
+	s.mu.RUnlock()
+
+	if req.Header.Get("Digest") == "" {
+		body, err := requestBody(req)
+		if err != nil {
+			return fmt.Errorf("Request body could not be read to sign; %v", err)
+		}
+		setContentDigest(req.Header, body)
+	}
+	created := s.now().Unix()
+	signingString, err := hmacSigningString(req, components, created)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HMACSignatureHeader, fmt.Sprintf(`keyId=%q,algorithm="hmac-sha256",created=%d,headers=%q,signature=%q`,
+		keyID, created, strings.Join(components, " "), hmacSign(key, signingString)))
+	return nil
+}
+
+// Verify checks that a request received is signed with one of the keys within HMACSignatureMaxAge, covering the signer's components and the body.
+func (s *HMACSigner) Verify(req *http.Request) error {
+	params := signatureParams(req.Header.Get(HMACSignatureHeader))
+	if params["signature"] == "" {
+		return errors.New("Request is not signed")
+	}
+	s.mu.RLock()
+	key, ok := s.keys[params["keyid"]]
+	required := s.components
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("HMAC key %s not known", params["keyid"])
+	}
+	if params["algorithm"] != "" && params["algorithm"] != "hmac-sha256" {
+		return fmt.Errorf("Signature algorithm %s not supported", params["algorithm"])
+	}
+	created, err := strconv.ParseInt(params["created"], 10, 64)
+	if err != nil {
+		return errors.New("Signature creation time not valid")
+	}
+	if age := s.now().Sub(time.Unix(created, 0)); age > HMACSignatureMaxAge || age < -HMACSignatureMaxAge {
+		return errors.New("Signature has expired")
+	}
+	components := strings.Fields(params["headers"])
+	for _, r := range required {
+		if !contains(components, r) {
+			return fmt.Errorf("Signature does not cover %s", r)
+		}
+	}
+	signingString, err := hmacSigningString(req, components, created)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(params["signature"]), []byte(hmacSign(key, signingString))) {
+		return errors.New("Signature does not match")
+	}
+	for _, c := range components {
+		if c == "digest" {
+			var body []byte
+			if req.Body != nil {
+				body, err = ioutil.ReadAll(req.Body)
+				if err != nil {
+					return fmt.Errorf("Request body could not be read; %v", err)
+				}
+				req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			h := make(http.Header)
+			setContentDigest(h, body)
+			if req.Header.Get("Digest") != h.Get("Digest") {
+				return errors.New("Body does not match the signed digest")
+			}
+		}
+	}
+	return nil
+}
+
+// hmacSigningString returns the string to sign for the components of the request given.
+func hmacSigningString(req *http.Request, components []string, created int64) (string, error) {
+	lines := make([]string, len(components))
+	for i, c := range components {
+		var v string
+		switch c {
+		case "(request-target)":
+			v = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "(created)":
+			v = strconv.FormatInt(created, 10)
+		case "host":
+			v = req.Host
+			if v == "" {
+				v = req.URL.Host
+			}
+		default:
+			vs := append([]string(nil), req.Header.Values(c)...)
+			if len(vs) == 0 {
+				return "", fmt.Errorf("Header %s to sign is not set", c)
+			}
+			for j := range vs {
+				vs[j] = strings.TrimSpace(vs[j])
+			}
+			v = strings.Join(vs, ", ")
+		}
+		lines[i] = c + ": " + v
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func hmacSign(key []byte, signingString string) string {
+	return base64.StdEncoding.EncodeToString(hmacSHA256(key, signingString))
+}
+
+// signatureParams parses the parameters of a Signature header. Parameter names are converted to lower case.
+func signatureParams(header string) map[string]string {
+	params := make(map[string]string)
+	p := &authParser{s: header}
+	for {
+		p.skip(" \t,")
+		name := p.token()
+		if name == "" || !p.consume('=') {
+			return params
+		}
+		params[strings.ToLower(name)] = p.value()
+	}
+}
@@ -0,0 +1,114 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHMACSigner_Authenticate(t *testing.T) {
+	s := NewHMACSigner("key1", []byte("secret"))
+	s.now = func() time.Time { return time.Unix(1402170695, 0) }
+	req, _ := http.NewRequest("POST", "http://example.com/foo?param=value&pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Content-Type", "application/json")
+	s.WithSignedHeaders("Content-Type")
+	err := s.Authenticate(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=", req.Header.Get("Digest"))
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", req.Header.Get("Content-Digest"))
+
+	params := signatureParams(req.Header.Get(HMACSignatureHeader))
+	assert.Equal(t, "key1", params["keyid"])
+	assert.Equal(t, "hmac-sha256", params["algorithm"])
+	assert.Equal(t, "1402170695", params["created"])
+	assert.Equal(t, "(request-target) (created) host digest content-type", params["headers"])
+	ss, _ := hmacSigningString(req, strings.Fields(params["headers"]), 1402170695)
+	assert.Equal(t, "(request-target): post /foo?param=value&pet=dog\n(created): 1402170695\nhost: example.com\n"+
+		"digest: SHA-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=\ncontent-type: application/json", ss)
+	assert.Equal(t, hmacSign([]byte("secret"), ss), params["signature"])
+
+	req.Header.Del("Content-Type")
+	assert.NotNil(t, s.Authenticate(context.Background(), req), "Expected an error when a header to sign is missing")
+}
+
+func TestHMACSigner_KeyRotation(t *testing.T) {
+	s := NewHMACSigner("key1", []byte("secret1"))
+	assert.NotNil(t, s.RemoveKey("key1"), "Key in use should not be removable")
+	s.WithKey("key2", []byte("secret2"))
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	s.Authenticate(context.Background(), req)
+	assert.Equal(t, "key2", signatureParams(req.Header.Get(HMACSignatureHeader))["keyid"], "New key not used")
+
+	assert.Nil(t, s.UseKey("key1"))
+	req, _ = http.NewRequest("GET", "http://example.com/", nil)
+	s.Authenticate(context.Background(), req)
+	assert.Equal(t, "key1", signatureParams(req.Header.Get(HMACSignatureHeader))["keyid"])
+	assert.NotNil(t, s.UseKey("key3"), "Unknown key should not be usable")
+
+	s.UseKey("key2")
+	assert.Nil(t, s.Verify(req), "Signature with the old key should still verify")
+	assert.Nil(t, s.RemoveKey("key1"))
+	assert.NotNil(t, s.Verify(req), "Signature with a removed key should not verify")
+}
+
+func TestHMACSigner_VerifyCoverage(t *testing.T) {
+	s := NewHMACSigner("key1", []byte("secret"))
+	req, _ := http.NewRequest("POST", "http://example.com/items", strings.NewReader("data"))
+	created := time.Now().Unix()
+	for _, covered := range []string{"(created)", "(created) host digest", ""} {
+		signingString, _ := hmacSigningString(req, strings.Fields(covered), created)
+		req.Header.Set(HMACSignatureHeader, fmt.Sprintf(`keyId="key1",algorithm="hmac-sha256",created=%d,headers=%q,signature=%q`,
+			created, covered, hmacSign([]byte("secret"), signingString)))
+		assert.NotNil(t, s.Verify(req), "Signature covering only %q should not verify", covered)
+	}
+
+	// Headers the signer is configured to sign are required as well
+	s.Authenticate(context.Background(), req)
+	assert.Nil(t, s.Verify(req))
+	s.WithSignedHeaders("Content-Type")
+	assert.NotNil(t, s.Verify(req), "Signature not covering a configured header should not verify")
+}
+
+func TestSend_HMACSigner(t *testing.T) {
+	gateway := NewHMACSigner("key1", []byte("secret"))
+	var mu sync.Mutex
+	var errs []error
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		err := gateway.Verify(r)
+		errs = append(errs, err)
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(NewHMACSigner("key1", []byte("secret")).WithSignedHeaders("Content-Type"))
+	r, _ := BuildRequest(c, NewPostOperation().WithPath("/items").WithQueryDataString("a=1").WithBodyDataString(`{"a": 1}`))
+	assert.Equal(t, "SHA-256=+dhgKMbg1k4iUYb5astpM4ssWXZN95FiEH9cS7NNExA=", r.HTTPRequest.Header.Get("Digest"), "Digest not added when building the request")
+	code, _ := Send(r)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Nil(t, errs[0], "Signature not verified")
+	assert.Equal(t, `{"a": 1}`, bodies[0], "Body not readable after verification")
+
+	// A body that does not match the digest is detected
+	r2, _ := BuildRequest(c, NewPostOperation().WithPath("/items").WithBodyDataString(`{"a": 2}`))
+	r2.HTTPRequest.Header.Set("Digest", r.HTTPRequest.Header.Get("Digest"))
+	code, _ = Send(r2)
+	assert.Equal(t, http.StatusUnauthorized, *code, "Body not matching the digest should not verify")
+
+	gateway.now = func() time.Time { return time.Now().Add(time.Hour) }
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusUnauthorized, *code, "Old signatures should not verify")
+}
@@ -0,0 +1,471 @@
+package restclient
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Algorithms of RFC 9421 HTTP Message Signatures.
+const (
+	SignatureAlgEd25519   = "ed25519"
+	SignatureAlgECDSAP256 = "ecdsa-p256-sha256"
+	SignatureAlgRSAPSS    = "rsa-pss-sha512"
+	SignatureAlgHMAC      = "hmac-sha256"
+)
+
+// Components covered by a MessageSigner unless others are given.
+var DefaultSignatureComponents = []string{"@method", "@target-uri", "content-digest"}
+
+// ErrSignatureInvalid is returned when a message's RFC 9421 signature is missing or cannot be verified.
+var ErrSignatureInvalid = errors.New("HTTP message signature is not valid")
+
+// A MessageSigner signs requests using RFC 9421 HTTP Message Signatures, covering the method, target URI and Content-Digest by default.
+type MessageSigner struct {
+	label      string
+	keyID      string
+	alg        string
+	key        interface{}
+	components []string
+	now        func() time.Time
+}
+
+// Create a new MessageSigner with an ed25519.PrivateKey, an *ecdsa.PrivateKey on P-256, an *rsa.PrivateKey for RSA-PSS or a []byte HMAC secret.
+func NewMessageSigner(keyID string, key interface{}) (*MessageSigner, error) {
+	alg, err := signatureAlgorithm(key, true)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageSigner{
+		label:      "sig1",
+		keyID:      keyID,
+		alg:        alg,
+		key:        key,
+		components: DefaultSignatureComponents,
+		now:        time.Now,
+	}, nil
+}
+
+// Cover the components given, derived components such as "@method" and "@authority" or header names, instead of the defaults.
+func (s *MessageSigner) WithComponents(components ...string) *MessageSigner {
+	s.components = make([]string, len(components))
+	for i, c := range components {
+		s.components[i] = strings.ToLower(c)
+	}
+	return s
+}
+
+// Use the label given for the signature instead of "sig1".
+func (s *MessageSigner) WithLabel(label string) *MessageSigner {
+	s.label = label
+	return s
+}
+
+func (s *MessageSigner) contentDigest() {}
+
+// Authenticate signs the request and sets the Signature-Input and Signature headers.
+func (s *MessageSigner) Authenticate(ctx context.Context, req *http.Request) error {
+	if req.Header.Get("Content-Digest") == "" && s.covers("content-digest") {
+		body, err := requestBody(req)
+		if err != nil {
+			return fmt.Errorf("Request body could not be read to sign; %v", err)
+		}
+		setContentDigest(req.Header, body)
+	}
+	params := fmt.Sprintf("%s;created=%d;keyid=%q;alg=%q", componentList(s.components), s.now().Unix(), s.keyID, s.alg)
+	base, err := signatureBase(requestComponents(req), s.components, params)
+	if err != nil {
+		return err
+	}
+	sig, err := sign(s.alg, s.key, base)
+	if err != nil {
+		return fmt.Errorf("Request could not be signed; %v", err)
+	}
+	req.Header.Set("Signature-Input", s.label+"="+params)
+	req.Header.Set("Signature", s.label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+func (s *MessageSigner) covers(component string) bool {
+	for _, c := range s.components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// A MessageVerifier verifies RFC 9421 HTTP Message Signatures made with known keys that cover the required components and the body's digest.
+type MessageVerifier struct {
+	mu       sync.RWMutex
+	keys     map[string]interface{}
+	required []string
+	maxAge   time.Duration
+	now      func() time.Time
+}
+
+// Create a new MessageVerifier without keys.
+func NewMessageVerifier() *MessageVerifier {
+	return &MessageVerifier{
+		keys: make(map[string]interface{}),
+		now:  time.Now,
+	}
+}
+
+// Add a key to verify signatures with: an ed25519.PublicKey, an *ecdsa.PublicKey on P-256, an *rsa.PublicKey for RSA-PSS or a []byte HMAC secret.
+func (v *MessageVerifier) WithKey(keyID string, key interface{}) *MessageVerifier {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[keyID] = key
+	return v
+}
+
+// Require signatures to cover the components given, in addition to those always required.
+func (v *MessageVerifier) WithRequiredComponents(components ...string) *MessageVerifier {
+	for _, c := range components {
+		v.required = append(v.required, strings.ToLower(c))
+	}
+	return v
+}
+
+// Reject signatures created longer ago than the age given. Signatures of any age are accepted by default so cached responses can be verified.
+func (v *MessageVerifier) WithMaxAge(d time.Duration) *MessageVerifier {
+	v.maxAge = d
+	return v
+}
+
+// VerifyResponse verifies a signature on the response with the body given.
+func (v *MessageVerifier) VerifyResponse(resp *http.Response, body []byte) error {
+	return v.verify(responseComponents(resp), resp.Header, body, []string{"@status", "content-digest"})
+}
+
+// VerifyRequest verifies a signature on a request, with the body given, received by a server.
+func (v *MessageVerifier) VerifyRequest(req *http.Request, body []byte) error {
+	return v.verify(requestComponents(req), req.Header, body, []string{"@method", "@target-uri", "content-digest"})
+}
+
+// verify checks that one of the signatures in the headers is valid and covers the components given as well as those required by the verifier.
+func (v *MessageVerifier) verify(components func(name string) (string, error), h http.Header, body []byte, required []string) error {
+	inputs := parseSignatureDictionary(h.Values("Signature-Input"))
+	sigs := parseSignatureDictionary(h.Values("Signature"))
+	if len(inputs) == 0 {
+		return fmt.Errorf("%w; the message is not signed", ErrSignatureInvalid)
+	}
+	var errs []string
+	for label, input := range inputs {
+		err := v.verifySignature(components, h, body, input, sigs[label], append(required, v.required...))
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", label, err))
+	}
+	return fmt.Errorf("%w; %s", ErrSignatureInvalid, strings.Join(errs, ", "))
+}
+
+func (v *MessageVerifier) verifySignature(components func(name string) (string, error), h http.Header, body []byte, input, signature string, required []string) error {
+	covered, params, err := parseSignatureInput(input)
+	if err != nil {
+		return err
+	}
+	for _, r := range required {
+		found := false
+		for _, c := range covered {
+			found = found || c == r
+		}
+		if !found {
+			return fmt.Errorf("Required component %s is not covered", r)
+		}
+	}
+	v.mu.RLock()
+	key, ok := v.keys[params["keyid"]]
+	v.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("Key %s is not known", params["keyid"])
+	}
+	alg, err := signatureAlgorithm(key, false)
+	if err != nil {
+		return err
+	}
+	if params["alg"] != "" && params["alg"] != alg {
+		return fmt.Errorf("Algorithm %s does not match the key", params["alg"])
+	}
+	if v.maxAge > 0 {
+		created, err := strconv.ParseInt(params["created"], 10, 64)
+		if err != nil || v.now().Sub(time.Unix(created, 0)) > v.maxAge {
+			return errors.New("Signature has expired")
+		}
+	}
+	if !strings.HasPrefix(signature, ":") || !strings.HasSuffix(signature, ":") || len(signature) < 2 {
+		return errors.New("Signature not found")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature[1 : len(signature)-1])
+	if err != nil {
+		return errors.New("Signature is not valid base64")
+	}
+	base, err := signatureBase(components, covered, input)
+	if err != nil {
+		return err
+	}
+	if !verifySignature(alg, key, base, sig) {
+		return errors.New("Signature does not match")
+	}
+	for _, c := range covered {
+		if c == "content-digest" {
+			d := make(http.Header)
+			setContentDigest(d, body)
+			if !strings.Contains(h.Get("Content-Digest"), d.Get("Content-Digest")) {
+				return errors.New("Body does not match the Content-Digest header")
+			}
+		}
+	}
+	return nil
+}
+
+// Verify the RFC 9421 signatures of responses to requests sent using this config, failing with ErrSignatureInvalid.
+func (c *Config) WithResponseVerifier(v *MessageVerifier) *Config {
+	c.responseVerifier = v
+	return c
+}
+
+// signatureAlgorithm returns the algorithm to use with the key.
+func signatureAlgorithm(key interface{}, private bool) (string, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		if private {
+			return SignatureAlgEd25519, nil
+		}
+	case ed25519.PublicKey:
+		if !private {
+			return SignatureAlgEd25519, nil
+		}
+	case *ecdsa.PrivateKey:
+		if private && k.Curve == elliptic.P256() {
+			return SignatureAlgECDSAP256, nil
+		}
+	case *ecdsa.PublicKey:
+		if !private && k.Curve == elliptic.P256() {
+			return SignatureAlgECDSAP256, nil
+		}
+	case *rsa.PrivateKey:
+		if private {
+			return SignatureAlgRSAPSS, nil
+		}
+	case *rsa.PublicKey:
+		if !private {
+			return SignatureAlgRSAPSS, nil
+		}
+	case []byte:
+		return SignatureAlgHMAC, nil
+	}
+	return "", fmt.Errorf("Key of type %T is not supported for HTTP message signatures", key)
+}
+
+func sign(alg string, key interface{}, base string) ([]byte, error) {
+	switch alg {
+	case SignatureAlgEd25519:
+		return ed25519.Sign(key.(ed25519.PrivateKey), []byte(base)), nil
+	case SignatureAlgECDSAP256:
+		h := sha256.Sum256([]byte(base))
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), h[:])
+		if err != nil {
+			return nil, err
+		}
+		// The signature is the concatenation of r and s, each 32 bytes
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case SignatureAlgRSAPSS:
+		h := sha512.Sum512([]byte(base))
+		return rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA512, h[:], &rsa.PSSOptions{SaltLength: 64})
+	default:
+		return hmacSHA256(key.([]byte), base), nil
+	}
+}
+
+func verifySignature(alg string, key interface{}, base string, sig []byte) bool {
+	switch alg {
+	case SignatureAlgEd25519:
+		return ed25519.Verify(key.(ed25519.PublicKey), []byte(base), sig)
+	case SignatureAlgECDSAP256:
+		if len(sig) != 64 {
+			return false
+		}
+		h := sha256.Sum256([]byte(base))
+		return ecdsa.Verify(key.(*ecdsa.PublicKey), h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case SignatureAlgRSAPSS:
+		h := sha512.Sum512([]byte(base))
+		return rsa.VerifyPSS(key.(*rsa.PublicKey), crypto.SHA512, h[:], sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) == nil
+	default:
+		return hmac.Equal(sig, hmacSHA256(key.([]byte), base))
+	}
+}
+
+// signatureBase creates the signature base of RFC 9421 section 2.5 for the covered components and serialized signature parameters.
+func signatureBase(components func(name string) (string, error), covered []string, params string) (string, error) {
+	var b strings.Builder
+	for _, c := range covered {
+		v, err := components(c)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%q: %s\n", c, v)
+	}
+	fmt.Fprintf(&b, "%q: %s", "@signature-params", params)
+	return b.String(), nil
+}
+
+// componentList serializes the component identifiers as a structured field inner list.
+func componentList(components []string) string {
+	q := make([]string, len(components))
+	for i, c := range components {
+		q[i] = strconv.Quote(c)
+	}
+	return "(" + strings.Join(q, " ") + ")"
+}
+
+// requestComponents returns a function giving the values of the components of a request, sent or received.
+func requestComponents(req *http.Request) func(string) (string, error) {
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	return func(name string) (string, error) {
+		switch name {
+		case "@method":
+			return req.Method, nil
+		case "@target-uri":
+			return u.String(), nil
+		case "@authority":
+			return strings.ToLower(u.Host), nil
+		case "@scheme":
+			return strings.ToLower(u.Scheme), nil
+		case "@path":
+			if p := u.EscapedPath(); p != "" {
+				return p, nil
+			}
+			return "/", nil
+		case "@query":
+			return "?" + u.RawQuery, nil
+		case "@request-target":
+			return u.RequestURI(), nil
+		}
+		return headerComponent(req.Header, name)
+	}
+}
+
+// responseComponents returns a function giving the values of the components of a response.
+func responseComponents(resp *http.Response) func(string) (string, error) {
+	return func(name string) (string, error) {
+		if name == "@status" {
+			return strconv.Itoa(resp.StatusCode), nil
+		}
+		return headerComponent(resp.Header, name)
+	}
+}
+
+// headerComponent returns the value of a header component, with multiple values combined.
+func headerComponent(h http.Header, name string) (string, error) {
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("Component %s is not supported", name)
+	}
+	vs := h.Values(name)
+	if len(vs) == 0 {
+		return "", fmt.Errorf("Header %s to sign is not set", name)
+	}
+	t := make([]string, len(vs))
+	for i, v := range vs {
+		t[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(t, ", "), nil
+}
+
+// parseSignatureDictionary splits the members of Signature-Input or Signature header values by label, keeping their serialized values.
+func parseSignatureDictionary(values []string) map[string]string {
+	d := make(map[string]string)
+	for _, v := range values {
+		p := &authParser{s: v}
+		for {
+			p.skip(" \t,")
+			label := p.token()
+			if label == "" || !p.consume('=') {
+				break
+			}
+			start := p.i
+			inString, depth := false, 0
+			for ; !p.atEnd(); p.i++ {
+				c := p.s[p.i]
+				if inString {
+					if c == '\\' {
+						p.i++
+					} else if c == '"' {
+						inString = false
+					}
+					continue
+				}
+				if c == '"' {
+					inString = true
+				} else if c == '(' {
+					depth++
+				} else if c == ')' {
+					depth--
+				} else if c == ',' && depth == 0 {
+					break
+				}
+			}
+			d[label] = strings.TrimSpace(p.s[start:p.i])
+		}
+	}
+	return d
+}
+
+// parseSignatureInput returns the covered components and parameters of a Signature-Input member.
+func parseSignatureInput(input string) ([]string, map[string]string, error) {
+	end := strings.Index(input, ")")
+	if !strings.HasPrefix(input, "(") || end < 0 {
+		return nil, nil, errors.New("Signature input is not an inner list")
+	}
+	var covered []string
+	for _, c := range strings.Fields(input[1:end]) {
+		name, err := strconv.Unquote(c)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Component %s is not supported", c)
+		}
+		covered = append(covered, name)
+	}
+	params := make(map[string]string)
+	for _, p := range strings.Split(input[end+1:], ";") {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := kv[1]
+		if uq, err := strconv.Unquote(v); err == nil {
+			v = uq
+		}
+		params[strings.TrimSpace(kv[0])] = v
+	}
+	return covered, params, nil
+}
@@ -0,0 +1,239 @@
+package restclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc9421Request returns the example request of RFC 9421 appendix B.2.
+func rfc9421Request() *http.Request {
+	req, _ := http.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "18")
+	return req
+}
+
+func TestMessageVerifier_RFC9421Vectors(t *testing.T) {
+	b, _ := base64.StdEncoding.DecodeString("MC4CAQAwBQYDK2VwBCIEIJ+DYvh6SEqVTm50DFtMDoQikTmiCqirVv9mWG9qfSnF")
+	k, err := x509.ParsePKCS8PrivateKey(b)
+	if err != nil {
+		t.Fatalf("Error parsing test key: %v", err)
+	}
+	edKey := k.(ed25519.PrivateKey)
+	b, _ = base64.StdEncoding.DecodeString("MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=")
+	edPub, _ := x509.ParsePKIXPublicKey(b)
+	assert.Equal(t, edKey.Public(), edPub)
+	secret, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+
+	var tests = []struct {
+		name      string
+		key       interface{}
+		pub       interface{}
+		input     string
+		base      string
+		signature string
+	}{
+		{
+			"B.2.5 HMAC-SHA256",
+			secret,
+			secret,
+			`("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+			`"date": Tue, 20 Apr 2021 02:07:55 GMT` + "\n" +
+				`"@authority": example.com` + "\n" +
+				`"content-type": application/json` + "\n" +
+				`"@signature-params": ("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`,
+			"pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=",
+		},
+		{
+			"B.2.6 Ed25519",
+			edKey,
+			edPub,
+			`("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`,
+			`"date": Tue, 20 Apr 2021 02:07:55 GMT` + "\n" +
+				`"@method": POST` + "\n" +
+				`"@path": /foo` + "\n" +
+				`"@authority": example.com` + "\n" +
+				`"content-type": application/json` + "\n" +
+				`"content-length": 18` + "\n" +
+				`"@signature-params": ("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`,
+			"wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==",
+		},
+	}
+	for _, test := range tests {
+		req := rfc9421Request()
+		covered, params, err := parseSignatureInput(test.input)
+		assert.Nil(t, err, test.name)
+		base, err := signatureBase(requestComponents(req), covered, test.input)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.base, base, test.name+": signature base not as expected")
+		alg, _ := signatureAlgorithm(test.key, true)
+		sig, err := sign(alg, test.key, base)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.signature, base64.StdEncoding.EncodeToString(sig), test.name+": signature not as expected")
+
+		req.Header.Set("Signature-Input", "sig1="+test.input)
+		req.Header.Set("Signature", "sig1=:"+test.signature+":")
+		v := NewMessageVerifier().WithKey(params["keyid"], test.pub)
+		// The examples do not cover the components required by default
+		assert.NotNil(t, v.VerifyRequest(req, []byte(`{"hello": "world"}`)), test.name+": signature not covering the default components verified")
+		assert.Nil(t, v.verify(requestComponents(req), req.Header, []byte(`{"hello": "world"}`), nil), test.name+": signature not verified")
+		req.Header.Set("Content-Type", "text/plain")
+		assert.True(t, errors.Is(v.verify(requestComponents(req), req.Header, nil, nil), ErrSignatureInvalid), test.name+": changed header not detected")
+	}
+}
+
+func TestMessageSigner_Algorithms(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var tests = []struct {
+		key interface{}
+		pub interface{}
+		alg string
+	}{
+		{edKey, edKey.Public(), SignatureAlgEd25519},
+		{ecKey, &ecKey.PublicKey, SignatureAlgECDSAP256},
+		{rsaKey, &rsaKey.PublicKey, SignatureAlgRSAPSS},
+		{[]byte("secret"), []byte("secret"), SignatureAlgHMAC},
+	}
+	for _, test := range tests {
+		s, err := NewMessageSigner("key1", test.key)
+		if err != nil {
+			t.Fatalf("Error creating signer for %s: %v", test.alg, err)
+		}
+		s.now = func() time.Time { return time.Unix(1618884473, 0) }
+		req := rfc9421Request()
+		assert.Nil(t, s.Authenticate(context.Background(), req), test.alg)
+		assert.Equal(t, `sig1=("@method" "@target-uri" "content-digest");created=1618884473;keyid="key1";alg="`+test.alg+`"`,
+			req.Header.Get("Signature-Input"), test.alg)
+		assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", req.Header.Get("Content-Digest"), test.alg)
+
+		v := NewMessageVerifier().WithKey("key1", test.pub)
+		assert.Nil(t, v.VerifyRequest(req, []byte(`{"hello": "world"}`)), test.alg+": signature not verified")
+		assert.NotNil(t, v.VerifyRequest(req, []byte(`{"hello": "there"}`)), test.alg+": changed body not detected")
+		req.Method = "PUT"
+		assert.NotNil(t, v.VerifyRequest(req, []byte(`{"hello": "world"}`)), test.alg+": changed method not detected")
+	}
+
+	_, err := NewMessageSigner("key1", &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: elliptic.P384()}})
+	assert.NotNil(t, err, "P-384 keys should not be supported")
+	_, err = NewMessageSigner("key1", "secret")
+	assert.NotNil(t, err, "Keys of unknown type should not be supported")
+}
+
+func TestMessageVerifier_Policy(t *testing.T) {
+	s, _ := NewMessageSigner("key1", []byte("secret"))
+	s.WithComponents("@method", "@authority", "@path", "@query").WithLabel("req")
+	req := rfc9421Request()
+	s.Authenticate(context.Background(), req)
+	assert.True(t, strings.HasPrefix(req.Header.Get("Signature-Input"), `req=("@method" "@authority" "@path" "@query");created=`))
+	assert.Equal(t, "", req.Header.Get("Content-Digest"), "Content-Digest should not be added when not covered")
+
+	v := NewMessageVerifier().WithKey("key1", []byte("secret"))
+	assert.NotNil(t, v.VerifyRequest(req, nil), "Signature not covering the target URI and Content-Digest should not verify")
+
+	s.WithComponents("@method", "@target-uri", "content-digest", "@authority")
+	req = rfc9421Request()
+	s.Authenticate(context.Background(), req)
+	body := []byte(`{"hello": "world"}`)
+	assert.Nil(t, v.VerifyRequest(req, body))
+	assert.NotNil(t, NewMessageVerifier().WithKey("key2", []byte("secret")).VerifyRequest(req, body), "Unknown key should not verify")
+	assert.NotNil(t, NewMessageVerifier().WithKey("key1", []byte("other")).VerifyRequest(req, body), "Wrong key should not verify")
+	v.WithRequiredComponents("Content-Type")
+	assert.NotNil(t, v.VerifyRequest(req, body), "Signature not covering the required components should not verify")
+
+	v = NewMessageVerifier().WithKey("key1", []byte("secret")).WithMaxAge(time.Minute)
+	v.now = func() time.Time { return time.Now().Add(time.Hour) }
+	assert.NotNil(t, v.VerifyRequest(req, body), "Old signature should not verify")
+
+	req.Header.Del("Signature-Input")
+	assert.True(t, errors.Is(v.VerifyRequest(req, nil), ErrSignatureInvalid), "Unsigned request should not verify")
+
+	// Responses must cover the status and Content-Digest
+	v = NewMessageVerifier().WithKey("key1", []byte("secret"))
+	resp := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+	setContentDigest(resp.Header, body)
+	for _, covered := range [][]string{{}, {"@status"}, {"@status", "content-digest"}} {
+		params := componentList(covered) + `;keyid="key1"`
+		base, _ := signatureBase(responseComponents(resp), covered, params)
+		sig, _ := sign(SignatureAlgHMAC, []byte("secret"), base)
+		resp.Header.Set("Signature-Input", "res="+params)
+		resp.Header.Set("Signature", "res=:"+base64.StdEncoding.EncodeToString(sig)+":")
+		if len(covered) == 2 {
+			assert.Nil(t, v.VerifyResponse(resp, body), "Response signature not verified")
+		} else {
+			assert.NotNil(t, v.VerifyResponse(resp, body), "Response signature covering only %v should not verify", covered)
+		}
+	}
+}
+
+func TestParseSignatureDictionary(t *testing.T) {
+	d := parseSignatureDictionary([]string{
+		`sig1=("@method" "a,b");created=1;keyid="x,y", sig2=("@status")`,
+		`sig3=:abc=:`,
+	})
+	assert.Equal(t, map[string]string{
+		"sig1": `("@method" "a,b");created=1;keyid="x,y"`,
+		"sig2": `("@status")`,
+		"sig3": ":abc=:",
+	}, d)
+}
+
+func TestSend_ResponseVerifier(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	body := `{"Field": "value"}`
+	var forged bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		v := NewMessageVerifier().WithKey("client", []byte("secret"))
+		if err := v.VerifyRequest(r, b); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		setContentDigest(w.Header(), []byte(body))
+		params := `("@status" "content-type" "content-digest");created=1618884473;keyid="server"`
+		base, _ := signatureBase(responseComponents(&http.Response{StatusCode: http.StatusOK, Header: w.Header()}),
+			[]string{"@status", "content-type", "content-digest"}, params)
+		sig, _ := sign(SignatureAlgEd25519, key, base)
+		w.Header().Set("Signature-Input", "res="+params)
+		w.Header().Set("Signature", "res=:"+base64.StdEncoding.EncodeToString(sig)+":")
+		if forged {
+			w.Write([]byte(`{"Field": "forged"}`))
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+	var d struct {
+		Field string
+	}
+
+	signer, _ := NewMessageSigner("client", []byte("secret"))
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(signer).WithResponseVerifier(NewMessageVerifier().WithKey("server", key.Public()))
+	r, _ := BuildRequest(c, NewPostOperation().WithPath("/items").WithBodyDataString(`{"a": 1}`).WithResponseTarget(&d))
+	assert.Equal(t, "sha-256=:+dhgKMbg1k4iUYb5astpM4ssWXZN95FiEH9cS7NNExA=:", r.HTTPRequest.Header.Get("Content-Digest"), "Content-Digest not added when building the request")
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code, "Request signature not verified")
+	assert.Equal(t, "value", d.Field)
+
+	forged = true
+	_, err = Send(r)
+	assert.True(t, errors.Is(err, ErrSignatureInvalid), "Response not matching its signature should be rejected")
+}
@@ -0,0 +1,54 @@
+package restclient
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Header carrying the idempotency key of an Operation.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Attach a newly generated, unique idempotency key to the Operation.
+// The key is sent in the Idempotency-Key header and stays the same for every Request built from the Operation,
+// so the ReST service can recognise repeated attempts of the same call.
+// An Operation with an idempotency key is treated as idempotent, so POST and PATCH Operations can then be retried.
+func (o *Operation) WithIdempotencyKey() *Operation {
+	k, err := newIdempotencyKey()
+	if err != nil {
+		// The key is left unset so the Operation is not treated as idempotent
+		return o
+	}
+	return o.WithIdempotencyKeyString(k)
+}
+
+// Attach the idempotency key given to the Operation. See WithIdempotencyKey.
+func (o *Operation) WithIdempotencyKeyString(k string) *Operation {
+	return o.WithHeader(IdempotencyKeyHeader, k)
+}
+
+// IdempotencyKey returns the idempotency key of the Operation, or an empty string if it does not have one.
+func (o *Operation) IdempotencyKey() string {
+	return o.header.Get(IdempotencyKeyHeader)
+}
+
+// Idempotent returns whether sending the Operation more than once has the same effect as sending it once.
+// GET and PUT Operations are idempotent, as is any Operation with an idempotency key.
+func (o *Operation) Idempotent() bool {
+	switch o.httpMethod {
+	case "GET", "PUT":
+		return true
+	}
+	return o.IdempotencyKey() != ""
+}
+
+// newIdempotencyKey generates a random (version 4) UUID.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("Idempotency key could not be generated; %v", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
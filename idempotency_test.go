@@ -0,0 +1,94 @@
+package restclient
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOperation_WithIdempotencyKey(t *testing.T) {
+	o := NewPostOperation().WithIdempotencyKey()
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), o.IdempotencyKey(), "Generated key is not a UUID")
+	assert.NotEqual(t, o.IdempotencyKey(), NewPostOperation().WithIdempotencyKey().IdempotencyKey(), "Generated keys should be unique")
+
+	c := NewConfig().WithEndPoint("http://test")
+	r1, _ := BuildRequest(c, o)
+	r2, _ := BuildRequest(c, o)
+	assert.Equal(t, o.IdempotencyKey(), r1.HTTPRequest.Header.Get("Idempotency-Key"), "Key not sent in header")
+	assert.Equal(t, r1.HTTPRequest.Header.Get("Idempotency-Key"), r2.HTTPRequest.Header.Get("Idempotency-Key"), "Key should be stable across requests")
+
+	o = NewPostOperation().WithIdempotencyKeyString("abc")
+	assert.Equal(t, "abc", o.IdempotencyKey())
+}
+
+func TestOperation_Idempotent(t *testing.T) {
+	assert.True(t, NewGetOperation().Idempotent())
+	assert.True(t, NewPutOperation().Idempotent())
+	assert.False(t, NewPostOperation().Idempotent())
+	assert.False(t, NewPatchOperation().Idempotent())
+	assert.True(t, NewPostOperation().WithIdempotencyKey().Idempotent())
+	assert.True(t, NewPatchOperation().WithIdempotencyKeyString("abc").Idempotent())
+}
+
+func TestIdempotencyKey_Retried(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithRetry(NewRetryPolicy(3).WithBackoff(time.Millisecond, time.Millisecond*10))
+	o := NewPostOperation().WithBodyDataString("payment").WithIdempotencyKey()
+	r, _ := BuildRequest(c, o)
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, 3, r.RetryAttempt, "POST with an idempotency key should be retried")
+	mu.Lock()
+	assert.Equal(t, []string{o.IdempotencyKey(), o.IdempotencyKey(), o.IdempotencyKey()}, keys, "Every attempt should carry the same key")
+	keys = nil
+	mu.Unlock()
+
+	// Without a key the POST is sent once
+	r, _ = BuildRequest(c, NewPostOperation().WithBodyDataString("payment"))
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusServiceUnavailable, *code)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, keys, 1, "POST without an idempotency key should not be retried")
+}
+
+func TestIdempotencyKey_NotHedged(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 50)
+	}))
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithHedging(NewHedgePolicy(time.Millisecond*5, 2))
+	for _, o := range []*Operation{NewPostOperation().WithIdempotencyKey(), NewPutOperation()} {
+		r, _ := BuildRequest(c, o)
+		Send(r)
+		assert.Equal(t, 0, r.HedgeAttempt, "Only GET requests should be hedged")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, calls, "Concurrent copies of unsafe requests should not be sent")
+}
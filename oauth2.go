@@ -0,0 +1,244 @@
+package restclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time before an OAuth2 access token expires at which it is no longer used and a new one is fetched.
+const OAuth2ExpiryDelta = 30 * time.Second
+
+// oauth2FetchTimeout limits how long a token fetch shared by the callers waiting on it may take.
+var oauth2FetchTimeout = time.Minute
+
+// An OAuth2Token is an access token issued by an OAuth2 authorization server.
+type OAuth2Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// The time the access token expires, calculated from ExpiresIn when the token is issued. Zero if it does not expire.
+	Expiry time.Time `json:"expiry,omitempty"`
+}
+
+// valid returns whether the access token can be used at the time given.
+func (t *OAuth2Token) valid(now time.Time) bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	return t.Expiry.IsZero() || now.Add(OAuth2ExpiryDelta).Before(t.Expiry)
+}
+
+// oauth2ErrorResponse is the error returned by an OAuth2 authorization server, defined in RFC 6749 section 5.2.
+type oauth2ErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// An oauth2Endpoint is the token endpoint of an OAuth2 authorization server and the client credentials used to authenticate to it.
+type oauth2Endpoint struct {
+	mu sync.Mutex
+	// The config the tokens are for, whose HTTP client is also used to call the token endpoint if it is set
+	parent       *Config
+	endPoint     string
+	path         string
+	query        string
+	clientID     string
+	clientSecret string
+}
+
+// newOAuth2Endpoint parses the token endpoint URL given.
+func newOAuth2Endpoint(parent *Config, tokenURL, clientID, clientSecret string) (*oauth2Endpoint, error) {
+	u, err := url.Parse(tokenURL)
+	if err != nil {
+		return nil, fmt.Errorf("OAuth2 token URL could not be parsed; %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("OAuth2 token URL is neither http:// nor https://")
+	}
+	return &oauth2Endpoint{
+		parent:       parent,
+		endPoint:     u.Scheme + "://" + u.Host,
+		path:         u.EscapedPath(),
+		query:        u.RawQuery,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}, nil
+}
+
+// setParent sets the config the tokens are for.
+func (e *oauth2Endpoint) setParent(c *Config) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.parent = c
+}
+
+// token requests a token from the endpoint with a form-encoded POST of the parameters given.
+// The client credentials are sent using basic authentication, as recommended by RFC 6749 section 2.3.1.
+// Public clients, which have no secret, send their client ID in the form instead.
+func (e *oauth2Endpoint) token(ctx context.Context, form url.Values) (*OAuth2Token, error) {
+	c := NewConfig().WithEndPoint(e.endPoint)
+	if e.clientSecret != "" {
+		c.WithUserId(url.QueryEscape(e.clientID)).WithPassword(url.QueryEscape(e.clientSecret))
+	} else {
+		form = copyValues(form)
+		form.Set("client_id", e.clientID)
+	}
+	e.mu.Lock()
+	parent := e.parent
+	e.mu.Unlock()
+	if parent != nil && parent.HTTPClient != nil {
+		c.HTTPClient = parent.HTTPClient
+	}
+	var t OAuth2Token
+	o := NewPostOperation().WithPath(e.path).WithQueryDataString(e.query).WithBodyDataURLValues(form).
+		WithHeader("Content-Type", "application/x-www-form-urlencoded").WithHeader("Accept", "application/json").
+		WithResponseTarget(&t)
+	r, err := BuildRequest(c, o)
+	if err != nil {
+		return nil, fmt.Errorf("OAuth2 token request could not be built; %v", err)
+	}
+	issued := time.Now()
+	// The status is checked before decoding as error responses do not contain a token
+	if err := r.roundTrip(ctx); err != nil {
+		return nil, fmt.Errorf("OAuth2 token request failed; %v", err)
+	}
+	if r.StatusCode != http.StatusOK {
+		var oe oauth2ErrorResponse
+		o.WithResponseTarget(&oe)
+		if r.decode() == nil && oe.Error != "" {
+			return nil, fmt.Errorf("OAuth2 token request received HTTP status %d; %s %s", r.StatusCode, oe.Error, oe.Description)
+		}
+		return nil, fmt.Errorf("OAuth2 token request received HTTP status %d", r.StatusCode)
+	}
+	if err := r.decode(); err != nil {
+		return nil, fmt.Errorf("OAuth2 token response could not be decoded; %v", err)
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("OAuth2 token response did not contain an access token")
+	}
+	if t.TokenType != "" && !strings.EqualFold(t.TokenType, "bearer") {
+		return nil, fmt.Errorf("OAuth2 token type %s is not supported", t.TokenType)
+	}
+	if t.ExpiresIn > 0 {
+		t.Expiry = issued.Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return &t, nil
+}
+
+// An oauth2Authenticator authenticates requests with an OAuth2 access token, which is cached until shortly before it expires.
+// Only one request for a new token is made at a time, other requests needing a token wait for its result.
+// If the ReST service responds with 401 Unauthorized the token is discarded and the request sent again with a new one.
+type oauth2Authenticator struct {
+	// fetch gets a new token, current is the token held, if any
+	fetch func(ctx context.Context, current *OAuth2Token) (*OAuth2Token, error)
+	now   func() time.Time
+	mu    sync.Mutex
+	token *OAuth2Token
+	call  *oauth2Fetch
+}
+
+// An oauth2Fetch is a request for a new token that is in flight.
+type oauth2Fetch struct {
+	done  chan struct{}
+	token *OAuth2Token
+	err   error
+}
+
+func newOAuth2Authenticator(fetch func(ctx context.Context, current *OAuth2Token) (*OAuth2Token, error)) *oauth2Authenticator {
+	return &oauth2Authenticator{
+		fetch: fetch,
+		now:   time.Now,
+	}
+}
+
+// Token returns a valid access token, fetching a new one if needed. Only one fetch is made at a time.
+func (a *oauth2Authenticator) Token(ctx context.Context) (*OAuth2Token, error) {
+	a.mu.Lock()
+	if a.token.valid(a.now()) {
+		t := a.token
+		a.mu.Unlock()
+		return t, nil
+	}
+	f := a.call
+	if f == nil {
+		f = &oauth2Fetch{done: make(chan struct{})}
+		a.call = f
+		go a.fetchToken(ctx, f, a.token)
+	}
+	a.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchToken makes the fetch for the callers waiting on it.
+func (a *oauth2Authenticator) fetchToken(ctx context.Context, f *oauth2Fetch, current *OAuth2Token) {
+	// The fetch is made separately from the first caller so cancelling it does not fail the others
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), oauth2FetchTimeout)
+	defer cancel()
+	f.token, f.err = a.fetch(ctx, current)
+	a.mu.Lock()
+	if f.err == nil {
+		a.token = f.token
+	}
+	a.call = nil
+	a.mu.Unlock()
+	close(f.done)
+}
+
+// Authenticate sets the bearer authorization header on the request with a valid access token.
+func (a *oauth2Authenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	t, err := a.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.AccessToken)
+	return nil
+}
+
+// HandleChallenge discards the token if the ReST service rejected it so that a new one is fetched for the retry.
+func (a *oauth2Authenticator) HandleChallenge(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Another request may already have replaced the rejected token
+	if a.token != nil && resp.Request != nil && resp.Request.Header.Get("Authorization") == "Bearer "+a.token.AccessToken {
+		// Keep the rest of the token, such as any refresh token, for fetching the next
+		t := *a.token
+		t.AccessToken = ""
+		a.token = &t
+	}
+	return true
+}
+
+// Authenticate requests sent using this config with access tokens obtained using the OAuth2 client credentials grant, defined in RFC 6749 section 4.4.
+// Tokens are requested from the token endpoint URL given, authenticating with the client ID and secret, and are cached until shortly before they expire.
+// The token endpoint is called using the HTTP client of this config.
+func (c *Config) WithOAuth2ClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) *Config {
+	e, err := newOAuth2Endpoint(c, tokenURL, clientID, clientSecret)
+	if err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
+		return c
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+	return c.WithAuthenticator(newOAuth2Authenticator(func(ctx context.Context, current *OAuth2Token) (*OAuth2Token, error) {
+		return e.token(ctx, form)
+	}))
+}
@@ -0,0 +1,212 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer is an OAuth2 token endpoint that issues numbered access tokens.
+type tokenServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	issued    int
+	expiresIn int
+	delay     time.Duration
+	forms     []map[string][]string
+}
+
+func newTokenServer(expiresIn int) *tokenServer {
+	s := &tokenServer{expiresIn: expiresIn}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Client credentials are form-encoded before basic authentication
+		id, secret, _ := r.BasicAuth()
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if r.Method != "POST" || r.URL.Path != "/oauth/token" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if id != "client" || secret != "s3cret%" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client", "error_description": "Client authentication failed"}`)
+			return
+		}
+		r.ParseForm()
+		time.Sleep(s.delay)
+		s.mu.Lock()
+		s.issued++
+		s.forms = append(s.forms, r.PostForm)
+		n := s.issued
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "Bearer", "expires_in": %d}`, n, s.expiresIn)
+	}))
+	return s
+}
+
+func (s *tokenServer) issuedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// bearerServer accepts requests with an access token that has not been revoked.
+func bearerServer(revoked map[string]bool, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		t := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if t == "" || revoked[t] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"token": %q}`, t)
+	}))
+}
+
+func TestConfig_WithOAuth2ClientCredentials(t *testing.T) {
+	ts := newTokenServer(3600)
+	defer ts.Close()
+	var mu sync.Mutex
+	revoked := make(map[string]bool)
+	s := bearerServer(revoked, &mu)
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithOAuth2ClientCredentials(ts.URL+"/oauth/token", "client", "s3cret%", "read", "write")
+	assert.Nil(t, c.Validate())
+	var d struct {
+		Token string `json:"token"`
+	}
+	for i := 0; i < 3; i++ {
+		r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+		code, err := Send(r)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, *code)
+		assert.Equal(t, "token1", d.Token, "Cached token not used")
+	}
+	assert.Equal(t, 1, ts.issuedCount(), "Token should be cached")
+	assert.Equal(t, "client_credentials", ts.forms[0]["grant_type"][0])
+	assert.Equal(t, "read write", ts.forms[0]["scope"][0])
+
+	// A rejected token is refreshed and the request retried once
+	mu.Lock()
+	revoked["token1"] = true
+	mu.Unlock()
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code, "Request not retried with a new token")
+	assert.Equal(t, "token2", d.Token)
+	assert.Equal(t, 2, ts.issuedCount())
+
+	mu.Lock()
+	revoked["token2"] = true
+	revoked["token3"] = true
+	mu.Unlock()
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusUnauthorized, *code, "Request should only be retried once")
+	assert.Equal(t, 3, ts.issuedCount())
+}
+
+func TestOAuth2Authenticator_Expiry(t *testing.T) {
+	ts := newTokenServer(60)
+	defer ts.Close()
+	c := NewConfig().WithOAuth2ClientCredentials(ts.URL+"/oauth/token", "client", "s3cret%")
+	a := c.auth.(*oauth2Authenticator)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	tk, err := a.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "token1", tk.AccessToken)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), tk.Expiry, time.Second*5, "Expiry not calculated from expires_in")
+
+	now = now.Add(time.Second * 20)
+	tk, _ = a.Token(context.Background())
+	assert.Equal(t, "token1", tk.AccessToken, "Token should still be valid")
+
+	// Within the expiry delta
+	now = now.Add(time.Second * 20)
+	tk, _ = a.Token(context.Background())
+	assert.Equal(t, "token2", tk.AccessToken, "Token should be refreshed shortly before it expires")
+}
+
+func TestOAuth2Authenticator_SingleFetch(t *testing.T) {
+	ts := newTokenServer(3600)
+	ts.delay = time.Millisecond * 100
+	defer ts.Close()
+	c := NewConfig().WithOAuth2ClientCredentials(ts.URL+"/oauth/token", "client", "s3cret%")
+	a := c.auth.(*oauth2Authenticator)
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tk, err := a.Token(context.Background())
+			if err == nil {
+				tokens[i] = tk.AccessToken
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, ts.issuedCount(), "Only one token request should be made at a time")
+	for _, tk := range tokens {
+		assert.Equal(t, "token1", tk)
+	}
+}
+
+func TestOAuth2Authenticator_CancelFirst(t *testing.T) {
+	ts := newTokenServer(3600)
+	ts.delay = time.Millisecond * 100
+	defer ts.Close()
+	c := NewConfig().WithOAuth2ClientCredentials(ts.URL+"/oauth/token", "client", "s3cret%")
+	a := c.auth.(*oauth2Authenticator)
+
+	// Cancelling the caller that started the fetch does not fail the others waiting on it
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	errc := make(chan error)
+	go func() {
+		_, err := a.Token(ctx)
+		errc <- err
+	}()
+	time.Sleep(time.Millisecond * 5)
+	tk, err := a.Token(context.Background())
+	assert.Nil(t, err, "Fetch failed when the first caller was cancelled")
+	if assert.NotNil(t, tk) {
+		assert.Equal(t, "token1", tk.AccessToken)
+	}
+	assert.Equal(t, context.DeadlineExceeded, <-errc)
+	assert.Equal(t, 1, ts.issuedCount())
+
+	// A fetch that does not complete is timed out
+	defer func(d time.Duration) { oauth2FetchTimeout = d }(oauth2FetchTimeout)
+	oauth2FetchTimeout = time.Millisecond * 20
+	a.token = nil
+	_, err = a.Token(context.Background())
+	assert.NotNil(t, err, "Expected the fetch to time out")
+}
+
+func TestOAuth2Authenticator_Errors(t *testing.T) {
+	ts := newTokenServer(3600)
+	defer ts.Close()
+
+	c := NewConfig().WithEndPoint("http://test").WithOAuth2ClientCredentials(ts.URL+"/oauth/token", "client", "wrong")
+	_, err := c.auth.(*oauth2Authenticator).Token(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid_client", "Error from the authorization server not reported")
+
+	c = NewConfig().WithEndPoint("http://test").WithOAuth2ClientCredentials("ftp://test/token", "client", "s3cret%")
+	assert.NotNil(t, c.Validate(), "Invalid token URL should make the config invalid")
+}
@@ -0,0 +1,238 @@
+package restclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Time the redirect listener of AuthorizeWithPKCE waits for the response to the browser to complete before closing.
+const redirectShutdownTimeout = 5 * time.Second
+
+// ErrOAuth2AuthorizationRequired is returned by an OAuth2TokenSource that has no valid token and no refresh token to get one with.
+// The user needs to authorize the client, for example with AuthorizeWithPKCE.
+var ErrOAuth2AuthorizationRequired = errors.New("OAuth2 authorization required, there is no valid token or refresh token")
+
+// An OAuth2TokenSource provides access tokens for a user who has authorized the client, for example a command line tool.
+// Expired tokens are renewed using the refresh token grant and every new token is saved to the OAuth2TokenStore.
+// A user authorizes the client using the authorization code grant with PKCE by calling AuthorizeWithPKCE.
+//
+// An OAuth2TokenSource is an Authenticator so it can be added to a Config with WithOAuth2TokenSource.
+type OAuth2TokenSource struct {
+	*oauth2Authenticator
+	endpoint  *oauth2Endpoint
+	store     OAuth2TokenStore
+	loaded    bool
+	onSaveErr func(error)
+}
+
+// Create a new OAuth2TokenSource that gets tokens from the token endpoint URL given, saving them to the store.
+// The client secret may be empty for public clients, in which case the client ID is sent in the token requests instead.
+// If store is nil the token is held in memory.
+func NewOAuth2TokenSource(tokenURL, clientID, clientSecret string, store OAuth2TokenStore) (*OAuth2TokenSource, error) {
+	e, err := newOAuth2Endpoint(nil, tokenURL, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		store = NewMemoryTokenStore()
+	}
+	s := &OAuth2TokenSource{
+		endpoint: e,
+		store:    store,
+	}
+	s.oauth2Authenticator = newOAuth2Authenticator(s.fetch)
+	return s, nil
+}
+
+// Authenticate requests sent using this config with access tokens from the OAuth2TokenSource.
+// The token endpoint is called using the HTTP client of this config.
+func (c *Config) WithOAuth2TokenSource(s *OAuth2TokenSource) *Config {
+	s.endpoint.setParent(c)
+	return c.WithAuthenticator(s)
+}
+
+// Call the function given with the error when a renewed token cannot be saved to the store.
+// The renewed token is still used, so a rotated refresh token is not lost, and is saved again with the next token.
+func (s *OAuth2TokenSource) WithSaveErrorHandler(f func(error)) *OAuth2TokenSource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onSaveErr = f
+	return s
+}
+
+// fetch loads the token saved in the store the first time it is called, then uses the refresh token to renew it when it is no longer valid.
+func (s *OAuth2TokenSource) fetch(ctx context.Context, current *OAuth2Token) (*OAuth2Token, error) {
+	if !s.loaded {
+		t, err := s.store.Load()
+		if err != nil {
+			return nil, err
+		}
+		s.loaded = true
+		if current == nil && t != nil {
+			current = t
+			if current.valid(s.now()) {
+				return current, nil
+			}
+		}
+	}
+	if current == nil || current.RefreshToken == "" {
+		return nil, ErrOAuth2AuthorizationRequired
+	}
+	t, err := s.endpoint.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {current.RefreshToken},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("OAuth2 token could not be refreshed; %v", err)
+	}
+	// The authorization server may not issue a new refresh token, in which case the current one remains valid
+	if t.RefreshToken == "" {
+		t.RefreshToken = current.RefreshToken
+	}
+	// The current refresh token may no longer be valid so the new token is used even if it cannot be saved
+	if err := s.save(t); err != nil {
+		s.mu.Lock()
+		f := s.onSaveErr
+		s.mu.Unlock()
+		if f != nil {
+			f(err)
+		}
+	}
+	return t, nil
+}
+
+// save saves the token to the store.
+func (s *OAuth2TokenSource) save(t *OAuth2Token) error {
+	if err := s.store.Save(t); err != nil {
+		return fmt.Errorf("OAuth2 token could not be saved; %v", err)
+	}
+	return nil
+}
+
+// setToken replaces the token held, which is saved to the store.
+func (s *OAuth2TokenSource) setToken(t *OAuth2Token) error {
+	s.mu.Lock()
+	s.token = t
+	s.mu.Unlock()
+	return s.save(t)
+}
+
+// Authorize the client with the OAuth2 authorization code grant, using PKCE as defined in RFC 7636, and a loopback redirect as described in RFC 8252.
+//
+// A listener is started on a random port of the loopback interface to receive the redirect from the authorization server.
+// open is called with the URL of the authorization endpoint the user needs to visit, for example to open it in a browser or print it.
+// Once the user has authorized the client the code received is exchanged for a token, which is saved to the store and used for subsequent requests.
+// AuthorizeWithPKCE returns when the token has been received or the context is done.
+func (s *OAuth2TokenSource) AuthorizeWithPKCE(ctx context.Context, authURL string, scopes []string, open func(authURL string) error) (*OAuth2Token, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, fmt.Errorf("OAuth2 authorization URL could not be parsed; %v", err)
+	}
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomURLString(16)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("Listener for the OAuth2 redirect could not be started; %v", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s/callback", l.Addr().String())
+
+	type callback struct {
+		code string
+		err  error
+	}
+	received := make(chan callback, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		var cb callback
+		switch {
+		case q.Get("state") != state:
+			cb.err = errors.New("OAuth2 redirect state does not match the authorization request")
+		case q.Get("error") != "":
+			cb.err = fmt.Errorf("OAuth2 authorization failed; %s %s", q.Get("error"), q.Get("error_description"))
+		case q.Get("code") == "":
+			cb.err = errors.New("OAuth2 redirect did not contain an authorization code")
+		default:
+			cb.code = q.Get("code")
+		}
+		if cb.err != nil {
+			http.Error(w, cb.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Authorization complete, you can close this window.")
+		}
+		select {
+		case received <- cb:
+		default:
+		}
+	})}
+	go srv.Serve(l)
+	defer func() {
+		// Allow the response to the browser to complete
+		sctx, cancel := context.WithTimeout(context.Background(), redirectShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", s.endpoint.clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if len(scopes) > 0 {
+		q.Set("scope", strings.Join(scopes, " "))
+	}
+	u.RawQuery = q.Encode()
+	if err := open(u.String()); err != nil {
+		return nil, fmt.Errorf("OAuth2 authorization URL could not be opened; %v", err)
+	}
+
+	var cb callback
+	select {
+	case cb = <-received:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if cb.err != nil {
+		return nil, cb.err
+	}
+	t, err := s.endpoint.token(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {cb.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("OAuth2 authorization code could not be exchanged for a token; %v", err)
+	}
+	return t, s.setToken(t)
+}
+
+// randomURLString returns n random bytes encoded as unpadded base64url.
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Random value could not be generated; %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
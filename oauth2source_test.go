@@ -0,0 +1,240 @@
+package restclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authServer is an OAuth2 authorization server for a public client that supports the authorization code grant with PKCE and refresh tokens.
+type authServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	challenge string
+	issued    int
+	refreshes int
+	expiresIn int
+	// Whether a new refresh token is issued when refreshing
+	rotate bool
+}
+
+func newAuthServer() *authServer {
+	s := &authServer{expiresIn: 3600}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("client_id") != "cli" || q.Get("code_challenge_method") != "S256" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.challenge = q.Get("code_challenge")
+		s.mu.Unlock()
+		// The user approves straight away
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code=authcode&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("client_id") != "cli" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": "invalid_client"}`)
+			return
+		}
+		switch r.PostForm.Get("grant_type") {
+		case "authorization_code":
+			h := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+			if r.PostForm.Get("code") != "authcode" || base64.RawURLEncoding.EncodeToString(h[:]) != s.challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": "invalid_grant"}`)
+				return
+			}
+			s.issued++
+			fmt.Fprintf(w, `{"access_token": "access%d", "token_type": "bearer", "expires_in": %d, "refresh_token": "refresh%d"}`, s.issued, s.expiresIn, s.issued)
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != fmt.Sprintf("refresh%d", s.issued) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": "invalid_grant"}`)
+				return
+			}
+			s.refreshes++
+			if s.rotate {
+				s.issued++
+				fmt.Fprintf(w, `{"access_token": "access%d", "expires_in": %d, "refresh_token": "refresh%d"}`, s.issued, s.expiresIn, s.issued)
+			} else {
+				fmt.Fprintf(w, `{"access_token": "refreshed%d", "expires_in": %d}`, s.refreshes, s.expiresIn)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "unsupported_grant_type"}`)
+		}
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// browse follows the authorization URL as a browser would, through the redirect back to the loopback listener.
+func browse(authURL string) error {
+	resp, err := http.Get(authURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func TestOAuth2TokenSource_AuthorizeWithPKCE(t *testing.T) {
+	as := newAuthServer()
+	defer as.Close()
+	var mu sync.Mutex
+	revoked := make(map[string]bool)
+	s := bearerServer(revoked, &mu)
+	defer s.Close()
+
+	store := NewMemoryTokenStore()
+	src, err := NewOAuth2TokenSource(as.URL+"/token", "cli", "", store)
+	if err != nil {
+		t.Fatalf("Error creating token source: %v", err)
+	}
+	c := NewConfig().WithEndPoint(s.URL).WithOAuth2TokenSource(src)
+
+	r, _ := BuildRequest(c, NewGetOperation())
+	_, err = Send(r)
+	assert.True(t, errors.Is(err, ErrOAuth2AuthorizationRequired), "Expected authorization to be required")
+
+	var authURL string
+	tk, err := src.AuthorizeWithPKCE(context.Background(), as.URL+"/authorize", []string{"profile"}, func(u string) error {
+		authURL = u
+		return browse(u)
+	})
+	if err != nil {
+		t.Fatalf("Error authorizing: %v", err)
+	}
+	assert.Equal(t, "access1", tk.AccessToken)
+	u, _ := url.Parse(authURL)
+	assert.Equal(t, "profile", u.Query().Get("scope"))
+	saved, _ := store.Load()
+	assert.Equal(t, "refresh1", saved.RefreshToken, "Token not saved to the store")
+
+	var d struct {
+		Token string `json:"token"`
+	}
+	r, _ = BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, "access1", d.Token, "Authorized token not used")
+
+	// A rejected token is refreshed
+	mu.Lock()
+	revoked["access1"] = true
+	mu.Unlock()
+	Send(r)
+	assert.Equal(t, "refreshed1", d.Token, "Token not refreshed")
+	saved, _ = store.Load()
+	assert.Equal(t, "refreshed1", saved.AccessToken, "Refreshed token not saved")
+	assert.Equal(t, "refresh1", saved.RefreshToken, "Refresh token should be kept when a new one is not issued")
+}
+
+func TestOAuth2TokenSource_AuthorizeWithPKCE_Errors(t *testing.T) {
+	src, _ := NewOAuth2TokenSource("http://127.0.0.1/token", "cli", "", nil)
+
+	// The redirect state does not match
+	_, err := src.AuthorizeWithPKCE(context.Background(), "http://127.0.0.1/authorize", nil, func(authURL string) error {
+		u, _ := url.Parse(authURL)
+		go http.Get(u.Query().Get("redirect_uri") + "?code=authcode&state=forged")
+		return nil
+	})
+	assert.NotNil(t, err, "Expected an error for a mismatched state")
+
+	// The user denies access
+	_, err = src.AuthorizeWithPKCE(context.Background(), "http://127.0.0.1/authorize", nil, func(authURL string) error {
+		u, _ := url.Parse(authURL)
+		go http.Get(u.Query().Get("redirect_uri") + "?error=access_denied&state=" + url.QueryEscape(u.Query().Get("state")))
+		return nil
+	})
+	assert.Contains(t, fmt.Sprint(err), "access_denied")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = src.AuthorizeWithPKCE(ctx, "http://127.0.0.1/authorize", nil, func(authURL string) error { return nil })
+	assert.Equal(t, context.DeadlineExceeded, err, "Expected the context error when no redirect is received")
+}
+
+func TestOAuth2TokenSource_Store(t *testing.T) {
+	as := newAuthServer()
+	as.rotate = true
+	defer as.Close()
+	as.issued = 1
+
+	// A token saved by a previous run is used until it expires
+	store := NewMemoryTokenStore()
+	store.Save(&OAuth2Token{AccessToken: "saved", RefreshToken: "refresh1", Expiry: time.Now().Add(time.Hour)})
+	src, _ := NewOAuth2TokenSource(as.URL+"/token", "cli", "", store)
+	tk, err := src.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "saved", tk.AccessToken, "Token from the store not used")
+
+	store.Save(&OAuth2Token{AccessToken: "saved", RefreshToken: "refresh1", Expiry: time.Now().Add(-time.Minute)})
+	src, _ = NewOAuth2TokenSource(as.URL+"/token", "cli", "", store)
+	tk, err = src.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "access2", tk.AccessToken, "Expired token from the store not refreshed")
+	saved, _ := store.Load()
+	assert.Equal(t, "refresh2", saved.RefreshToken, "Rotated refresh token not saved")
+
+	src, _ = NewOAuth2TokenSource(as.URL+"/token", "cli", "", nil)
+	_, err = src.Token(context.Background())
+	assert.Equal(t, ErrOAuth2AuthorizationRequired, err)
+}
+
+// failingTokenStore is an OAuth2TokenStore that cannot save tokens.
+type failingTokenStore struct {
+	*MemoryTokenStore
+}
+
+func (s failingTokenStore) Save(t *OAuth2Token) error {
+	return errors.New("disk full")
+}
+
+func TestOAuth2TokenSource_SaveError(t *testing.T) {
+	as := newAuthServer()
+	as.rotate = true
+	defer as.Close()
+	as.issued = 1
+
+	store := failingTokenStore{NewMemoryTokenStore()}
+	store.MemoryTokenStore.Save(&OAuth2Token{AccessToken: "saved", RefreshToken: "refresh1", Expiry: time.Now().Add(-time.Minute)})
+	var saveErrs []error
+	src, _ := NewOAuth2TokenSource(as.URL+"/token", "cli", "", store)
+	src.WithSaveErrorHandler(func(err error) {
+		saveErrs = append(saveErrs, err)
+	})
+	tk, err := src.Token(context.Background())
+	assert.Nil(t, err, "Refreshed token should be used even though it could not be saved")
+	assert.Equal(t, "access2", tk.AccessToken)
+	if assert.Len(t, saveErrs, 1, "Save error not reported") {
+		assert.Contains(t, saveErrs[0].Error(), "disk full")
+	}
+
+	// The rotated refresh token held in memory is used for the next refresh
+	src.now = func() time.Time { return time.Now().Add(time.Hour * 2) }
+	tk, err = src.Token(context.Background())
+	assert.Nil(t, err, "Rotated refresh token lost when it could not be saved")
+	assert.Equal(t, "access3", tk.AccessToken)
+	assert.Len(t, saveErrs, 2)
+}
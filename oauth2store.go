@@ -0,0 +1,103 @@
+package restclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// An OAuth2TokenStore persists the OAuth2 token of an OAuth2TokenSource, so that a user does not need to authorize again each time a program runs.
+// Load returns nil and no error if no token has been saved. Implementations must be safe for concurrent use.
+type OAuth2TokenStore interface {
+	Load() (*OAuth2Token, error)
+	Save(t *OAuth2Token) error
+}
+
+// MemoryTokenStore holds an OAuth2 token in memory.
+type MemoryTokenStore struct {
+	mu    sync.Mutex
+	token *OAuth2Token
+}
+
+// Create a new, empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{}
+}
+
+// Load returns a copy of the token held.
+func (s *MemoryTokenStore) Load() (*OAuth2Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil {
+		return nil, nil
+	}
+	t := *s.token
+	return &t, nil
+}
+
+// Save holds a copy of the token.
+func (s *MemoryTokenStore) Save(t *OAuth2Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *t
+	s.token = &c
+	return nil
+}
+
+// FileTokenStore holds an OAuth2 token as JSON in a file that only the owner can read.
+type FileTokenStore struct {
+	path string
+}
+
+// Create a new FileTokenStore holding the token in the file given. The directory of the file is created if it does not exist.
+func NewFileTokenStore(path string) (*FileTokenStore, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Token store directory could not be created; %v", err)
+	}
+	return &FileTokenStore{path: path}, nil
+}
+
+// Load reads the token from the file.
+func (s *FileTokenStore) Load() (*OAuth2Token, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Token could not be read from file; %v", err)
+	}
+	var t OAuth2Token
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, fmt.Errorf("Token file could not be parsed; %v", err)
+	}
+	return &t, nil
+}
+
+// Save writes the token to the file. The file is replaced atomically so a concurrent Load never sees a partial token.
+func (s *FileTokenStore) Save(t *OAuth2Token) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("Token could not be marshaled; %v", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), "tmp-")
+	if err != nil {
+		return fmt.Errorf("Token file could not be created; %v", err)
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("Token could not be written to file; %v", err)
+	}
+	return nil
+}
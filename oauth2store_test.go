@@ -0,0 +1,53 @@
+package restclient
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	s := NewMemoryTokenStore()
+	tk, err := s.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, tk, "Empty store should not return a token")
+
+	orig := &OAuth2Token{AccessToken: "access", RefreshToken: "refresh"}
+	s.Save(orig)
+	orig.AccessToken = "changed"
+	tk, _ = s.Load()
+	assert.Equal(t, "access", tk.AccessToken, "Store should hold a copy of the token")
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir, _ := ioutil.TempDir(os.TempDir(), "restclient-TestFileTokenStore")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app", "token.json")
+
+	s, err := NewFileTokenStore(path)
+	if err != nil {
+		t.Fatalf("Error creating file token store: %v", err)
+	}
+	tk, err := s.Load()
+	assert.Nil(t, err)
+	assert.Nil(t, tk, "Empty store should not return a token")
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	err = s.Save(&OAuth2Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry})
+	assert.Nil(t, err)
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Token file should only be readable by the owner")
+
+	s2, _ := NewFileTokenStore(path)
+	tk, err = s2.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, "refresh", tk.RefreshToken, "Token not persisted to file")
+	assert.True(t, expiry.Equal(tk.Expiry))
+
+	ioutil.WriteFile(path, []byte("not json"), 0600)
+	_, err = s2.Load()
+	assert.NotNil(t, err, "Expected an error for an invalid token file")
+}
@@ -2,6 +2,7 @@ package restclient
 
 import (
 	"encoding/json"
+	"net/http"
 	"net/url"
 	"reflect"
 )
@@ -14,6 +15,9 @@ type Operation struct {
 	httpPath    string
 	sendData    []byte
 	queryData   string
+	header      http.Header
+	hedgePolicy *HedgePolicy
+	retryPolicy *RetryPolicy
 	responsePtr interface{}
 }
 
@@ -95,6 +99,16 @@ func (o *Operation) WithQueryDataURLValues(d url.Values) *Operation {
 	return o
 }
 
+// Add a header to be sent with the Operation.
+// Headers set here take precedence over those the library sets by default, such as Content-Type.
+func (o *Operation) WithHeader(name, value string) *Operation {
+	if o.header == nil {
+		o.header = make(http.Header)
+	}
+	o.header.Set(name, value)
+	return o
+}
+
 // Define the pointer to a struct that will be used to hold the response data from the ReST call.
 // When the request is sent to the ReST service any response will be marshalled into this struct.
 func (o *Operation) WithResponseTarget(v interface{}) *Operation {
@@ -106,3 +120,16 @@ func (o *Operation) WithResponseTarget(v interface{}) *Operation {
 	o.responsePtr = v
 	return o
 }
+
+// clone returns a copy of the Operation that can be modified without affecting the original.
+// The response target is shared with the original.
+func (o *Operation) clone() *Operation {
+	c := *o
+	c.header = o.header.Clone()
+	return &c
+}
+
+// query returns the query string data of the Operation as a url.Values type.
+func (o *Operation) query() (url.Values, error) {
+	return url.ParseQuery(o.queryData)
+}
@@ -0,0 +1,444 @@
+package restclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A Paginator works out how to request the next page of a paginated listing from the ReST service.
+type Paginator interface {
+	// NextPage is passed the Request for the page just fetched, the number of items found on that page (-1 if this is not known) and the query data used to fetch it.
+	// It returns the query data to fetch the next page with and whether there is a next page.
+	NextPage(r *Request, items int, query url.Values) (next url.Values, more bool, err error)
+}
+
+// A URLPaginator is a Paginator that gives the full URL of the next page, rather than only its query data.
+// A PageIterator uses NextPageURL in place of NextPage if the Paginator implements it.
+type URLPaginator interface {
+	Paginator
+	NextPageURL(r *Request, items int) (next *url.URL, more bool, err error)
+}
+
+// LinkHeaderPaginator follows the RFC 5988 Link header with rel="next" returned by the ReST service.
+// The next link is resolved against the URL of the request and must be on the same host.
+type LinkHeaderPaginator struct{}
+
+// NextPage returns the query data from the next link, if the response has one.
+func (p LinkHeaderPaginator) NextPage(r *Request, items int, query url.Values) (url.Values, bool, error) {
+	u, more, err := p.NextPageURL(r, items)
+	if !more || err != nil {
+		return nil, more, err
+	}
+	return u.Query(), true, nil
+}
+
+// NextPageURL returns the URL of the next link, if the response has one.
+func (p LinkHeaderPaginator) NextPageURL(r *Request, items int) (*url.URL, bool, error) {
+	links := parseLinkHeader(r.HTTPResponse.Header.Values("Link"))
+	n, ok := links["next"]
+	if !ok {
+		return nil, false, nil
+	}
+	u, err := r.HTTPRequest.URL.Parse(n)
+	if err != nil {
+		return nil, false, fmt.Errorf("Link header next URL could not be parsed; %v", err)
+	}
+	// Credentials for the ReST service must not be sent elsewhere
+	if u.Scheme != r.HTTPRequest.URL.Scheme || u.Host != r.HTTPRequest.URL.Host {
+		return nil, false, fmt.Errorf("Link header next URL %s is not on the ReST service's host", u.Redacted())
+	}
+	return u, true, nil
+}
+
+// CursorPaginator reads an opaque cursor token from the response body and passes it back to the ReST service in a query parameter.
+// The CursorField is the name of the field in the JSON response holding the cursor. Nested fields are separated by dots, for example "meta.next_cursor".
+// Paging stops when the cursor is missing, null or empty.
+type CursorPaginator struct {
+	CursorField string
+	CursorParam string
+}
+
+// NextPage returns the query data with the cursor from the response set.
+func (p CursorPaginator) NextPage(r *Request, items int, query url.Values) (url.Values, bool, error) {
+	raw, ok, err := jsonField(r.ResponseBody, p.CursorField)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var cursor interface{}
+	err = json.Unmarshal(raw, &cursor)
+	if err != nil {
+		return nil, false, fmt.Errorf("Cursor field %s could not be parsed; %v", p.CursorField, err)
+	}
+	var c string
+	switch v := cursor.(type) {
+	case nil:
+		return nil, false, nil
+	case string:
+		c = v
+	default:
+		c = string(raw)
+	}
+	if c == "" {
+		return nil, false, nil
+	}
+	next := copyValues(query)
+	next.Set(p.CursorParam, c)
+	return next, true, nil
+}
+
+// PagePaginator increments a page number query parameter.
+// If PageSize is set it is sent in the SizeParam query parameter and paging stops when a page has fewer items than this.
+// Paging always stops when a page has no items, so the number of items on each page must be known.
+type PagePaginator struct {
+	PageParam string
+	FirstPage int
+	SizeParam string
+	PageSize  int
+}
+
+// NextPage returns the query data with the page number incremented.
+func (p PagePaginator) NextPage(r *Request, items int, query url.Values) (url.Values, bool, error) {
+	if items < 0 {
+		return nil, false, errors.New("PagePaginator requires the items field of the response to be defined")
+	}
+	if items == 0 || (p.PageSize > 0 && items < p.PageSize) {
+		return nil, false, nil
+	}
+	page := p.FirstPage
+	if v := query.Get(p.PageParam); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("Page query parameter %s is not a number; %v", p.PageParam, err)
+		}
+		page = n
+	}
+	next := copyValues(query)
+	next.Set(p.PageParam, strconv.Itoa(page+1))
+	if p.SizeParam != "" && p.PageSize > 0 {
+		next.Set(p.SizeParam, strconv.Itoa(p.PageSize))
+	}
+	return next, true, nil
+}
+
+// OffsetPaginator advances an offset query parameter by the number of items on each page.
+// If Limit is set it is sent in the LimitParam query parameter and paging stops when a page has fewer items than this.
+// Paging always stops when a page has no items, so the number of items on each page must be known.
+type OffsetPaginator struct {
+	OffsetParam string
+	LimitParam  string
+	Limit       int
+}
+
+// NextPage returns the query data with the offset advanced.
+func (p OffsetPaginator) NextPage(r *Request, items int, query url.Values) (url.Values, bool, error) {
+	if items < 0 {
+		return nil, false, errors.New("OffsetPaginator requires the items field of the response to be defined")
+	}
+	if items == 0 || (p.Limit > 0 && items < p.Limit) {
+		return nil, false, nil
+	}
+	offset := 0
+	if v := query.Get(p.OffsetParam); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("Offset query parameter %s is not a number; %v", p.OffsetParam, err)
+		}
+		offset = n
+	}
+	next := copyValues(query)
+	next.Set(p.OffsetParam, strconv.Itoa(offset+items))
+	if p.LimitParam != "" && p.Limit > 0 {
+		next.Set(p.LimitParam, strconv.Itoa(p.Limit))
+	}
+	return next, true, nil
+}
+
+// A PageIterator sends an Operation repeatedly to fetch each page of a paginated listing.
+// The query data of the Operation is used for the first page and the Paginator provides the query data, or URL, for each following page.
+// Any response target defined on the Operation is updated with each page.
+type PageIterator struct {
+	config     *Config
+	operation  *Operation
+	paginator  Paginator
+	itemsField *string
+	maxPages   int
+	maxItems   int
+	query      url.Values
+	nextURL    *url.URL
+	pages      int
+	itemCount  int
+	request    *Request
+	items      []json.RawMessage
+	done       bool
+	err        error
+}
+
+// Create a new PageIterator that will fetch pages by sending the Operation using the Config.
+func NewPageIterator(c *Config, o *Operation, p Paginator) *PageIterator {
+	return &PageIterator{
+		config:    c,
+		operation: o,
+		paginator: p,
+	}
+}
+
+// Define the field in the JSON response that holds the array of items on each page.
+// Nested fields are separated by dots, for example "data.items". An empty string means the response itself is an array.
+func (it *PageIterator) WithItemsField(f string) *PageIterator {
+	it.itemsField = &f
+	return it
+}
+
+// Limit the number of pages fetched. Zero means no limit.
+func (it *PageIterator) WithMaxPages(n int) *PageIterator {
+	it.maxPages = n
+	return it
+}
+
+// Limit the number of items returned. Zero means no limit.
+// Fetching stops once this many items have been seen and the items of the last page are truncated to the limit.
+// The items field must be defined for this to take effect.
+func (it *PageIterator) WithMaxItems(n int) *PageIterator {
+	it.maxItems = n
+	return it
+}
+
+// Fetch the next page, returning false when there are no more pages or an error occurs.
+// Check Err after Next returns false to find out if iteration stopped due to an error.
+func (it *PageIterator) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+	if err := ctx.Err(); err != nil {
+		return it.stop(err)
+	}
+	o := it.operation.clone()
+	if it.query == nil {
+		q, err := o.query()
+		if err != nil {
+			return it.stop(fmt.Errorf("Operation has invalid query data; %v", err))
+		}
+		it.query = q
+	} else {
+		o.WithQueryDataURLValues(it.query)
+	}
+	r, err := BuildRequest(it.config, o)
+	if err != nil {
+		return it.stop(err)
+	}
+	if it.nextURL != nil {
+		r.HTTPRequest.URL = it.nextURL
+	}
+	if err = r.roundTrip(ctx); err != nil {
+		return it.stop(err)
+	}
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return it.stop(fmt.Errorf("Page %d received HTTP status %d from the ReST service", it.pages+1, r.StatusCode))
+	}
+	if err = r.decode(); err != nil {
+		return it.stop(err)
+	}
+	it.request = r
+	it.pages++
+
+	n := -1
+	it.items = nil
+	if it.itemsField != nil {
+		it.items, err = pageItems(r.ResponseBody, *it.itemsField)
+		if err != nil {
+			return it.stop(err)
+		}
+		n = len(it.items)
+		if it.maxItems > 0 && it.itemCount+n >= it.maxItems {
+			it.items = it.items[:it.maxItems-it.itemCount]
+			it.done = true
+		}
+		it.itemCount += len(it.items)
+	}
+	if it.maxPages > 0 && it.pages >= it.maxPages {
+		it.done = true
+	}
+	if up, ok := it.paginator.(URLPaginator); ok && !it.done {
+		next, more, err := up.NextPageURL(r, n)
+		if err != nil {
+			it.err = err
+			it.done = true
+		} else if !more {
+			it.done = true
+		} else {
+			it.nextURL = next
+		}
+	} else if !it.done {
+		next, more, err := it.paginator.NextPage(r, n, it.query)
+		if err != nil {
+			it.err = err
+			it.done = true
+		} else if !more {
+			it.done = true
+		} else {
+			it.query = next
+		}
+	}
+	return true
+}
+
+// Request returns the Request for the current page.
+func (it *PageIterator) Request() *Request {
+	return it.request
+}
+
+// Items returns the raw JSON of each item on the current page. The items field must be defined.
+func (it *PageIterator) Items() []json.RawMessage {
+	return it.items
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *PageIterator) Err() error {
+	return it.err
+}
+
+// Call fn with each item of each page until there are no more pages, fn returns an error or the context is cancelled.
+// The items field must be defined.
+func (it *PageIterator) ForEachItem(ctx context.Context, fn func(item json.RawMessage) error) error {
+	if it.itemsField == nil {
+		return errors.New("The items field must be defined to iterate over items")
+	}
+	for it.Next(ctx) {
+		for _, item := range it.items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+	return it.err
+}
+
+func (it *PageIterator) stop(err error) bool {
+	it.err = err
+	it.done = true
+	return false
+}
+
+// pageItems extracts the array of items from a JSON page.
+func pageItems(body []byte, field string) ([]json.RawMessage, error) {
+	raw, ok, err := jsonField(body, field)
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return items, nil
+	}
+	err = json.Unmarshal(raw, &items)
+	if err != nil {
+		return nil, fmt.Errorf("Items field %q of the response is not an array; %v", field, err)
+	}
+	return items, nil
+}
+
+// jsonField returns the raw JSON of the field at the dot separated path within a JSON object.
+// An empty path returns the whole document.
+func jsonField(body []byte, path string) (json.RawMessage, bool, error) {
+	raw := json.RawMessage(body)
+	if path == "" {
+		return raw, true, nil
+	}
+	for _, f := range strings.Split(path, ".") {
+		var m map[string]json.RawMessage
+		err := json.Unmarshal(raw, &m)
+		if err != nil {
+			return nil, false, fmt.Errorf("Response could not be parsed to find field %s; %v", path, err)
+		}
+		var ok bool
+		raw, ok = m[f]
+		if !ok {
+			return nil, false, nil
+		}
+	}
+	return raw, true, nil
+}
+
+// parseLinkHeader returns the URLs of an RFC 5988 Link header keyed by their relation type.
+func parseLinkHeader(values []string) map[string]string {
+	links := make(map[string]string)
+	for _, v := range values {
+		for {
+			v = strings.TrimLeft(v, " \t,")
+			end := strings.IndexByte(v, '>')
+			if !strings.HasPrefix(v, "<") || end < 0 {
+				break
+			}
+			u := v[1:end]
+			v = v[end+1:]
+			for {
+				v = strings.TrimLeft(v, " \t")
+				if !strings.HasPrefix(v, ";") {
+					break
+				}
+				var name, value string
+				name, value, v = parseLinkParam(v[1:])
+				if strings.EqualFold(name, "rel") {
+					for _, rel := range strings.Fields(value) {
+						links[strings.ToLower(rel)] = u
+					}
+				}
+			}
+			// Skip anything else up to the next link
+			i := strings.IndexByte(v, ',')
+			if i < 0 {
+				break
+			}
+			v = v[i+1:]
+		}
+	}
+	return links
+}
+
+// parseLinkParam parses a link parameter, whose value may be a quoted string, returning its name and value and the rest of the header.
+func parseLinkParam(s string) (name, value, rest string) {
+	i := strings.IndexAny(s, "=;,")
+	if i < 0 {
+		return strings.TrimSpace(s), "", ""
+	}
+	name = strings.TrimSpace(s[:i])
+	if s[i] != '=' {
+		return name, "", s[i:]
+	}
+	s = strings.TrimLeft(s[i+1:], " \t")
+	if !strings.HasPrefix(s, `"`) {
+		j := strings.IndexAny(s, ";,")
+		if j < 0 {
+			return name, strings.TrimSpace(s), ""
+		}
+		return name, strings.TrimSpace(s[:j]), s[j:]
+	}
+	var b strings.Builder
+	for j := 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			if j+1 < len(s) {
+				j++
+				b.WriteByte(s[j])
+			}
+		case '"':
+			return name, b.String(), s[j+1:]
+		default:
+			b.WriteByte(s[j])
+		}
+	}
+	return name, b.String(), ""
+}
+
+func copyValues(v url.Values) url.Values {
+	c := make(url.Values, len(v))
+	for k, vs := range v {
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
@@ -0,0 +1,187 @@
+package restclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pagedServer serves the items 1 to total in pages of size items, with the page selected by the function provided.
+func pagedServer(total, size int, page func(w http.ResponseWriter, r *http.Request) int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := page(w, r)
+		var items []int
+		for i := p*size + 1; i <= total && i <= (p+1)*size; i++ {
+			items = append(items, i)
+		}
+		b, _ := json.Marshal(items)
+		fmt.Fprintf(w, `{"data": {"items": %s}, "next": %q}`, b, nextCursor(p, size, total))
+	}))
+}
+
+func nextCursor(p, size, total int) string {
+	if (p+1)*size >= total {
+		return ""
+	}
+	return "c" + strconv.Itoa(p+1)
+}
+
+func collectItems(t *testing.T, it *PageIterator) []int {
+	var got []int
+	err := it.ForEachItem(context.Background(), func(item json.RawMessage) error {
+		var i int
+		json.Unmarshal(item, &i)
+		got = append(got, i)
+		return nil
+	})
+	assert.Nil(t, err, "Error iterating items")
+	return got
+}
+
+func TestPageIterator_LinkHeader(t *testing.T) {
+	var s *httptest.Server
+	s = pagedServer(7, 3, func(w http.ResponseWriter, r *http.Request) int {
+		assert.Equal(t, "queryData", r.URL.Query().Get("queryKey"), "Original query data not kept")
+		p, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if p < 2 {
+			w.Header().Add("Link", fmt.Sprintf(`<%s/list?queryKey=queryData&page=%d>; rel="next", <%s/list?page=2>; rel="last"`, s.URL, p+1, s.URL))
+		}
+		return p
+	})
+	defer s.Close()
+
+	o := NewGetOperation().WithPath("/list").WithQueryDataString("queryKey=queryData")
+	it := NewPageIterator(NewConfig().WithEndPoint(s.URL), o, LinkHeaderPaginator{}).WithItemsField("data.items")
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7}, collectItems(t, it), "Items not as expected")
+}
+
+func TestPageIterator_Cursor(t *testing.T) {
+	s := pagedServer(7, 3, func(w http.ResponseWriter, r *http.Request) int {
+		c := r.URL.Query().Get("cursor")
+		if c == "" {
+			return 0
+		}
+		p, _ := strconv.Atoi(strings.TrimPrefix(c, "c"))
+		return p
+	})
+	defer s.Close()
+
+	type page struct {
+		Next string `json:"next"`
+	}
+	var d page
+	o := NewGetOperation().WithResponseTarget(&d)
+	it := NewPageIterator(NewConfig().WithEndPoint(s.URL), o, CursorPaginator{CursorField: "next", CursorParam: "cursor"})
+	var cursors []string
+	for it.Next(context.Background()) {
+		cursors = append(cursors, d.Next)
+		assert.Nil(t, it.Items(), "Items should not be set when the items field is not defined")
+	}
+	assert.Nil(t, it.Err(), "Error iterating pages")
+	assert.Equal(t, []string{"c1", "c2", ""}, cursors, "Pages not decoded into response target")
+}
+
+func TestPageIterator_PageAndOffset(t *testing.T) {
+	s := pagedServer(7, 3, func(w http.ResponseWriter, r *http.Request) int {
+		if v := r.URL.Query().Get("offset"); v != "" {
+			o, _ := strconv.Atoi(v)
+			return o / 3
+		}
+		p, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		return p
+	})
+	defer s.Close()
+	c := NewConfig().WithEndPoint(s.URL)
+
+	var tests = []struct {
+		paginator Paginator
+		maxPages  int
+		maxItems  int
+		expected  []int
+	}{
+		{PagePaginator{PageParam: "page"}, 0, 0, []int{1, 2, 3, 4, 5, 6, 7}},
+		{PagePaginator{PageParam: "page", SizeParam: "size", PageSize: 3}, 0, 0, []int{1, 2, 3, 4, 5, 6, 7}},
+		{OffsetPaginator{OffsetParam: "offset", LimitParam: "limit", Limit: 3}, 0, 0, []int{1, 2, 3, 4, 5, 6, 7}},
+		{OffsetPaginator{OffsetParam: "offset"}, 2, 0, []int{1, 2, 3, 4, 5, 6}},
+		{OffsetPaginator{OffsetParam: "offset"}, 0, 5, []int{1, 2, 3, 4, 5}},
+	}
+	for _, test := range tests {
+		it := NewPageIterator(c, NewGetOperation(), test.paginator).WithItemsField("data.items").WithMaxPages(test.maxPages).WithMaxItems(test.maxItems)
+		assert.Equal(t, test.expected, collectItems(t, it), "Items not as expected for %+v", test.paginator)
+	}
+
+	it := NewPageIterator(c, NewGetOperation(), PagePaginator{PageParam: "page"})
+	assert.True(t, it.Next(context.Background()), "First page should be fetched")
+	assert.False(t, it.Next(context.Background()), "PagePaginator should not continue without an items field")
+	assert.NotNil(t, it.Err(), "Expected an error without an items field")
+}
+
+func TestPageIterator_Cancel(t *testing.T) {
+	s := pagedServer(7, 3, func(w http.ResponseWriter, r *http.Request) int {
+		p, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		return p
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := NewPageIterator(NewConfig().WithEndPoint(s.URL), NewGetOperation(), PagePaginator{PageParam: "page"}).WithItemsField("data.items")
+	assert.True(t, it.Next(ctx), "First page should be fetched")
+	cancel()
+	assert.False(t, it.Next(ctx), "Iteration should stop when the context is cancelled")
+	assert.Equal(t, context.Canceled, it.Err(), "Expected the context error")
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	l := parseLinkHeader([]string{`<https://api.test/x?page=2>; rel="next", <https://api.test/x?page=9>; rel="last"`, `<https://api.test/x?page=1>; rel="prev first"`})
+	assert.Equal(t, map[string]string{
+		"next":  "https://api.test/x?page=2",
+		"last":  "https://api.test/x?page=9",
+		"prev":  "https://api.test/x?page=1",
+		"first": "https://api.test/x?page=1",
+	}, l, "Link header not parsed correctly")
+
+	// Commas and semicolons within URLs and quoted parameters
+	l = parseLinkHeader([]string{`<https://api.test/x?ids=1,2,3;v=1>; title="a, b; c" ; rel=next,<https://api.test/y>;rel="prev"; title="q \" ,"`})
+	assert.Equal(t, map[string]string{
+		"next": "https://api.test/x?ids=1,2,3;v=1",
+		"prev": "https://api.test/y",
+	}, l, "Link header with commas not parsed correctly")
+}
+
+func TestPageIterator_LinkHeaderPath(t *testing.T) {
+	var s *httptest.Server
+	var paths []string
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/list":
+			w.Header().Set("Link", `</list/page2?ids=4,5>; rel="next"`)
+			fmt.Fprint(w, `{"items": [1, 2, 3]}`)
+		case "/list/page2":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/list/page3>; rel="next"`, s.URL))
+			fmt.Fprint(w, `{"items": [4, 5]}`)
+		default:
+			w.Header().Set("Link", `<https://elsewhere.test/list/page4>; rel="next"`)
+			fmt.Fprint(w, `{"items": [6]}`)
+		}
+	}))
+	defer s.Close()
+
+	it := NewPageIterator(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithPath("/list"), LinkHeaderPaginator{}).WithItemsField("items")
+	var got []int
+	err := it.ForEachItem(context.Background(), func(item json.RawMessage) error {
+		var i int
+		json.Unmarshal(item, &i)
+		got = append(got, i)
+		return nil
+	})
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, got, "Items not as expected")
+	assert.Equal(t, []string{"/list", "/list/page2?ids=4,5", "/list/page3"}, paths, "Next link paths not followed")
+	assert.NotNil(t, err, "Next link to another host should not be followed")
+}
@@ -0,0 +1,93 @@
+package restclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"net/url"
+	"strings"
+)
+
+// A PinMismatchError is returned when none of the certificates presented by the ReST service have a pinned public key.
+type PinMismatchError struct {
+	Host string
+	// The pins of the public keys of the certificates presented
+	Presented []string
+}
+
+func (e *PinMismatchError) Error() string {
+	return fmt.Sprintf("Certificate presented by %s does not match any pinned public key; presented %s", e.Host, strings.Join(e.Presented, ", "))
+}
+
+// PublicKeyPin returns the pin of a certificate's public key, the base64 encoded SHA-256 hash of its SubjectPublicKeyInfo.
+func PublicKeyPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Only accept the ReST service's certificate if it, or one in its verified chain, has one of the public keys pinned, failing with a *PinMismatchError.
+func (c *Config) WithPinnedPublicKeys(pins ...string) *Config {
+	for _, p := range pins {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p, "sha256/"))
+		if err != nil || len(b) != sha256.Size {
+			c.configErr = multierror.Append(c.configErr, fmt.Errorf("Public key pin %q is not a base64 encoded SHA-256 hash", p))
+			continue
+		}
+		c.PinnedPublicKeys = append(c.PinnedPublicKeys, p)
+	}
+	c.tlsClientConfig().VerifyConnection = c.verifyConnection
+	return c
+}
+
+// Trust the ReST service's certificate only by its own pinned public key, without CA validation or host name checks, for example for self-signed appliances.
+func (c *Config) WithPinOnlyVerification() *Config {
+	c.PinOnly = true
+	cfg := c.tlsClientConfig()
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = c.verifyConnection
+	return c
+}
+
+// verifyPins checks the certificates presented against the pinned public keys, using the verified chains unless only pins are trusted.
+func (c *Config) verifyPins(cs tls.ConnectionState, chains [][]*x509.Certificate) error {
+	if c.PinOnly || len(chains) == 0 {
+		// Without a verified chain only the service's own certificate can be relied on
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("ReST service presented no certificate")
+		}
+		chains = [][]*x509.Certificate{{cs.PeerCertificates[0]}}
+	}
+	return c.checkPins(chains)
+}
+
+// checkPins returns a *PinMismatchError unless a certificate in one of the chains has a pinned public key.
+func (c *Config) checkPins(chains [][]*x509.Certificate) error {
+	pins := make(map[string]bool)
+	for _, p := range c.PinnedPublicKeys {
+		pins[strings.TrimPrefix(p, "sha256/")] = true
+	}
+	e := &PinMismatchError{}
+	if c.EndPoint != nil {
+		if u, err := url.Parse(*c.EndPoint); err == nil {
+			e.Host = u.Host
+		}
+	}
+	seen := make(map[string]bool)
+	for _, chain := range chains {
+		for _, cert := range chain {
+			pin := PublicKeyPin(cert)
+			if pins[pin] {
+				return nil
+			}
+			if !seen[pin] {
+				seen[pin] = true
+				e.Presented = append(e.Presented, pin)
+			}
+		}
+	}
+	return e
+}
@@ -0,0 +1,155 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublicKeyPin(t *testing.T) {
+	// Pin of the SubjectPublicKeyInfo of the certificate used by httptest servers
+	s := httptest.NewTLSServer(http.NotFoundHandler())
+	defer s.Close()
+	pin := PublicKeyPin(s.Certificate())
+	assert.Equal(t, 44, len(pin), "Pin is not a base64 encoded SHA-256 hash")
+	assert.NotEqual(t, pin, PublicKeyPin(newTestCA(t, "Test CA").cert))
+}
+
+func TestConfig_WithPinnedPublicKeys(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	s := newTLSServer(t, ca)
+	defer s.Close()
+	leaf, _ := x509.ParseCertificate(s.TLS.Certificates[0].Certificate[0])
+	other := newTestCA(t, "Other CA")
+
+	var tests = []struct {
+		pins  []string
+		match bool
+	}{
+		{[]string{PublicKeyPin(leaf)}, true},
+		{[]string{"sha256/" + PublicKeyPin(leaf)}, true},
+		{[]string{PublicKeyPin(ca.cert)}, true},
+		{[]string{PublicKeyPin(other.cert), PublicKeyPin(ca.cert)}, true},
+		{[]string{PublicKeyPin(other.cert)}, false},
+	}
+	for _, test := range tests {
+		c := NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithPinnedPublicKeys(test.pins...)
+		_, err := sendField(c)
+		if test.match {
+			assert.Nil(t, err, "Pins %v should match", test.pins)
+			continue
+		}
+		var pe *PinMismatchError
+		if assert.True(t, errors.As(err, &pe), "Error is not a PinMismatchError: %v", err) {
+			assert.Equal(t, s.Listener.Addr().String(), pe.Host)
+			assert.Equal(t, []string{PublicKeyPin(leaf), PublicKeyPin(ca.cert)}, pe.Presented)
+		}
+	}
+
+	// Pins are only checked after the chain is verified with a CA
+	c := NewConfig().WithEndPoint(s.URL).WithCACert(other.cert).WithPinnedPublicKeys(PublicKeyPin(leaf))
+	_, err := sendField(c)
+	var pe *PinMismatchError
+	assert.NotNil(t, err)
+	assert.False(t, errors.As(err, &pe), "Untrusted certificate should be rejected before pins are checked")
+
+	c = NewConfig().WithPinnedPublicKeys("not a pin", "c2hvcnQ=")
+	assert.NotNil(t, c.configErr, "Invalid pins did not create an error in the configuration")
+	assert.Equal(t, 0, len(c.PinnedPublicKeys))
+
+	c = NewConfig().WithPinnedPublicKeys(PublicKeyPin(leaf))
+	assert.NotNil(t, c.Warnings(), "Single pin without a backup should be warned about")
+	c.WithPinnedPublicKeys(PublicKeyPin(other.cert))
+	assert.Nil(t, c.Warnings())
+}
+
+func TestConfig_WithPinOnlyVerification(t *testing.T) {
+	// A self-signed appliance certificate
+	appliance := newTestCA(t, "Appliance")
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Field": "value"}`)
+	}))
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{appliance.cert.Raw}, PrivateKey: appliance.key}}}
+	s.StartTLS()
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithPinnedPublicKeys(PublicKeyPin(appliance.cert), PublicKeyPin(newTestCA(t, "Backup").cert)).WithPinOnlyVerification()
+	assert.Nil(t, c.Validate(), "HTTPS endpoint with pin only verification should be valid")
+	f, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", f)
+
+	// The pin must be of the service's own certificate
+	ca := newTestCA(t, "Test CA")
+	s2 := newTLSServer(t, ca)
+	defer s2.Close()
+	c = NewConfig().WithEndPoint(s2.URL).WithPinnedPublicKeys(PublicKeyPin(ca.cert)).WithPinOnlyVerification()
+	_, err = sendField(c)
+	var pe *PinMismatchError
+	assert.True(t, errors.As(err, &pe), "CA pin should not be trusted without CA validation: %v", err)
+
+	c = NewConfig().WithEndPoint(s.URL).WithPinOnlyVerification()
+	assert.NotNil(t, c.Validate(), "Pin only verification without pins should be invalid")
+}
+
+func TestConfig_WithPinnedPublicKeys_Reload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newTLSServer(t, ca)
+	defer s.Close()
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+
+	c := NewConfig().WithEndPoint(s.URL).WithCAFilePath(caPath).WithCertificateReload(time.Minute).WithPinnedPublicKeys(PublicKeyPin(ca.cert))
+	_, err := sendField(c)
+	assert.Nil(t, err)
+	c.PinnedPublicKeys = nil
+	c.WithPinnedPublicKeys(PublicKeyPin(newTestCA(t, "Other CA").cert))
+	_, err = sendField(c)
+	var pe *PinMismatchError
+	assert.True(t, errors.As(err, &pe), "Pins not checked when the certificate chain is verified by the reloader: %v", err)
+}
+
+func TestConfig_WithPinnedPublicKeys_Resume(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	s := newTLSStateServer(t, ca)
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithTLSSessionCache(10).WithPinnedPublicKeys(PublicKeyPin(ca.cert))
+	_, err := sendField(c)
+	assert.Nil(t, err)
+	f, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Contains(t, f, "true", "TLS session not resumed")
+	c.PinnedPublicKeys = nil
+	c.WithPinnedPublicKeys(PublicKeyPin(newTestCA(t, "Other CA").cert))
+	_, err = sendField(c)
+	var pe *PinMismatchError
+	assert.True(t, errors.As(err, &pe), "Pins not checked when the TLS session is resumed: %v", err)
+}
+
+func TestConfig_Load_PinnedPublicKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	appliance := newTestCA(t, "Appliance")
+	testConfigFile := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(testConfigFile, []byte(fmt.Sprintf(`{
+		"EndPoint": "https://appliance:8443",
+		"PinnedPublicKeys": [%q, "not a pin"],
+		"PinOnly": true
+	}`, PublicKeyPin(appliance.cert))), 0600)
+	c := Load(testConfigFile)
+	assert.NotNil(t, c.Validate(), "Invalid pin should make the configuration invalid")
+	assert.Equal(t, []string{PublicKeyPin(appliance.cert)}, c.PinnedPublicKeys)
+	assert.True(t, c.PinOnly)
+	assert.True(t, c.tlsClientConfig().InsecureSkipVerify)
+}
@@ -0,0 +1,189 @@
+package restclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// Default time between polls of the ReST service.
+	DefaultPollInterval = time.Second * 10
+	// Default response header from which the long-poll index is read. This is the header used by Consul.
+	DefaultPollIndexHeader = "X-Consul-Index"
+	// Default time to wait before polling again after an error. It doubles with each consecutive error up to DefaultPollMaxErrorBackoff.
+	DefaultPollErrorBackoff = time.Second
+	// Default longest time to wait before polling again after consecutive errors.
+	DefaultPollMaxErrorBackoff = time.Minute
+)
+
+// The shortest time between the start of one long poll and the next, so a service that responds immediately is not polled in a tight loop.
+var minLongPollInterval = time.Second
+
+// A Poller repeatedly sends an Operation to the ReST service and reports when the response has changed.
+// If the service returns an ETag it is sent back with If-None-Match so that unchanged bodies are neither transferred nor decoded.
+// Services that hold a request open until the data changes (such as Consul blocking queries) are supported with WithLongPoll.
+type Poller struct {
+	config      *Config
+	operation   *Operation
+	interval    time.Duration
+	wait        time.Duration
+	indexHeader string
+	etag        string
+	index       string
+	sum         [sha256.Size]byte
+	polled      bool
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	onError     func(err error)
+}
+
+// Create a new Poller that will send the Operation using the Config.
+// Any response target defined on the Operation is updated each time the response changes.
+func NewPoller(c *Config, o *Operation) *Poller {
+	return &Poller{
+		config:      c,
+		operation:   o,
+		interval:    DefaultPollInterval,
+		indexHeader: DefaultPollIndexHeader,
+		backoff:     DefaultPollErrorBackoff,
+		maxBackoff:  DefaultPollMaxErrorBackoff,
+	}
+}
+
+// Set the time to wait between polls.
+// When long polling the interval is the time to wait after the service responds before polling again and may be zero.
+func (p *Poller) WithInterval(d time.Duration) *Poller {
+	p.interval = d
+	return p
+}
+
+// Enable long polling.
+// The wait duration is passed to the service in the "wait" query parameter and the last index returned by the service in the "index" query parameter.
+// The index is read from the response header given, if this is empty the Consul X-Consul-Index header is used.
+// The interval is set to zero by this method, use WithInterval afterwards to set a delay between long polls.
+// Long polls are started at most once a second whatever the interval.
+func (p *Poller) WithLongPoll(wait time.Duration, indexHeader string) *Poller {
+	p.wait = wait
+	p.interval = 0
+	if indexHeader != "" {
+		p.indexHeader = indexHeader
+	}
+	return p
+}
+
+// Set the time to wait before polling again after an error, which doubles with each consecutive error up to the maximum given.
+func (p *Poller) WithErrorBackoff(d, max time.Duration) *Poller {
+	p.backoff = d
+	p.maxBackoff = max
+	return p
+}
+
+// Set a function to be called with each error from polling the ReST service, for example to log it.
+func (p *Poller) WithErrorHandler(f func(err error)) *Poller {
+	p.onError = f
+	return p
+}
+
+// Poll the ReST service until the context is cancelled or onChange returns an error.
+// onChange is called each time the response has changed, after any response data has been marshalled into the Operation's response target.
+// The first successful response is always considered a change.
+// Errors polling the service are passed to any error handler and the poll is retried with backoff.
+// If onChange returns an error polling stops and the error is returned.
+// Cancelling the context stops polling cleanly and nil is returned.
+func (p *Poller) Run(ctx context.Context, onChange func(r *Request) error) error {
+	backoff := p.backoff
+	for {
+		start := time.Now()
+		r, changed, err := p.Poll(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		delay := p.interval
+		if err != nil {
+			if p.onError != nil {
+				p.onError(err)
+			}
+			delay = backoff
+			if backoff *= 2; backoff > p.maxBackoff {
+				backoff = p.maxBackoff
+			}
+		} else {
+			backoff = p.backoff
+			if changed && onChange != nil {
+				if err := onChange(r); err != nil {
+					return err
+				}
+			}
+		}
+		if p.wait > 0 {
+			if d := minLongPollInterval - time.Since(start); d > delay {
+				delay = d
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Poll the ReST service once, returning the Request sent and whether the response has changed since the last poll.
+// The response data is only marshalled into the Operation's response target when it has changed.
+// A status code outside of the 2xx range, other than 304 Not Modified, results in an error.
+func (p *Poller) Poll(ctx context.Context) (r *Request, changed bool, err error) {
+	o := p.operation.clone()
+	if p.etag != "" {
+		o.WithHeader("If-None-Match", p.etag)
+	}
+	if p.wait > 0 {
+		q, qerr := o.query()
+		if qerr != nil {
+			err = fmt.Errorf("Poll operation has invalid query data; %v", qerr)
+			return
+		}
+		q.Set("wait", p.wait.String())
+		if p.index != "" {
+			q.Set("index", p.index)
+		}
+		o.WithQueryDataURLValues(q)
+	}
+	r, err = BuildRequest(p.config, o)
+	if err != nil {
+		return
+	}
+	err = r.roundTrip(ctx)
+	if err != nil {
+		return
+	}
+	if r.StatusCode == http.StatusNotModified {
+		return
+	}
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		err = fmt.Errorf("Poll received HTTP status %d from the ReST service", r.StatusCode)
+		return
+	}
+	etag := r.HTTPResponse.Header.Get("ETag")
+	index := r.HTTPResponse.Header.Get(p.indexHeader)
+	sum := sha256.Sum256(r.ResponseBody)
+	switch {
+	case !p.polled:
+		changed = true
+	case etag != "" || index != "":
+		changed = etag != "" && etag != p.etag || index != "" && index != p.index
+	default:
+		// Without either header the body itself is compared
+		changed = sum != p.sum
+	}
+	p.polled = true
+	p.etag = etag
+	p.index = index
+	p.sum = sum
+	if changed {
+		err = r.decode()
+	}
+	return
+}
@@ -0,0 +1,243 @@
+package restclient
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pollData struct {
+	Version int `json:"version"`
+}
+
+func TestPoller_Poll(t *testing.T) {
+	var mu sync.Mutex
+	version := 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag := fmt.Sprintf(`"v%d"`, version)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `{"version": %d}`, version)
+	}))
+	defer s.Close()
+
+	var d pollData
+	p := NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithPath("/config").WithResponseTarget(&d))
+
+	r, changed, err := p.Poll(context.Background())
+	assert.Nil(t, err, "Error on first poll")
+	assert.True(t, changed, "First poll should always be a change")
+	assert.Equal(t, 1, d.Version, "Response data not decoded on change")
+
+	d.Version = 0
+	r, changed, err = p.Poll(context.Background())
+	assert.Nil(t, err, "Error on second poll")
+	assert.False(t, changed, "Unchanged resource reported as changed")
+	assert.Equal(t, http.StatusNotModified, r.StatusCode, "If-None-Match not sent with poll")
+	assert.Equal(t, 0, d.Version, "Response data should not be decoded when unchanged")
+
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+	_, changed, err = p.Poll(context.Background())
+	assert.Nil(t, err, "Error on third poll")
+	assert.True(t, changed, "Changed resource not reported as changed")
+	assert.Equal(t, 2, d.Version, "Response data not decoded on change")
+}
+
+func TestPoller_Poll_Changes(t *testing.T) {
+	var mu sync.Mutex
+	var etag, index string
+	version := 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		if index != "" {
+			w.Header().Set("X-Consul-Index", index)
+		}
+		fmt.Fprintf(w, `{"version": %d}`, version)
+	}))
+	defer s.Close()
+	set := func(e, i string, v int) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag, index, version = e, i, v
+	}
+
+	var tests = []struct {
+		name    string
+		etag    string
+		index   string
+		version int
+		changed bool
+	}{
+		{"First poll", `"a"`, "1", 1, true},
+		{"Same headers", `"a"`, "1", 1, false},
+		{"New ETag with the same index", `"b"`, "1", 2, true},
+		{"New index with the same ETag", `"b"`, "2", 3, true},
+		{"Same headers with a new body", `"b"`, "2", 4, false},
+		{"Neither header", "", "", 4, false},
+		{"Neither header with the same body", "", "", 4, false},
+		{"Neither header with a new body", "", "", 5, true},
+	}
+	var d pollData
+	p := NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithResponseTarget(&d))
+	for _, test := range tests {
+		set(test.etag, test.index, test.version)
+		d.Version = 0
+		_, changed, err := p.Poll(context.Background())
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.changed, changed, test.name)
+		if test.changed {
+			assert.Equal(t, test.version, d.Version, "%s: response data not decoded on change", test.name)
+		} else {
+			assert.Equal(t, 0, d.Version, "%s: response data should not be decoded when unchanged", test.name)
+		}
+	}
+}
+
+func TestPoller_LongPoll(t *testing.T) {
+	var waits, indexes []string
+	var mu sync.Mutex
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		waits = append(waits, r.URL.Query().Get("wait"))
+		indexes = append(indexes, r.URL.Query().Get("index"))
+		if r.URL.Query().Get("queryKey") != "queryData" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "42")
+		fmt.Fprintln(w, `{"version": 1}`)
+	}))
+	defer s.Close()
+
+	var d pollData
+	o := NewGetOperation().WithQueryDataString("queryKey=queryData").WithResponseTarget(&d)
+	p := NewPoller(NewConfig().WithEndPoint(s.URL), o).WithLongPoll(time.Minute, "")
+
+	_, changed, err := p.Poll(context.Background())
+	assert.Nil(t, err, "Error on first long poll")
+	assert.True(t, changed, "First long poll should be a change")
+	_, changed, err = p.Poll(context.Background())
+	assert.Nil(t, err, "Error on second long poll")
+	assert.False(t, changed, "Same index should not be reported as a change")
+	assert.Equal(t, []string{"1m0s", "1m0s"}, waits, "Wait query parameter not sent")
+	assert.Equal(t, []string{"", "42"}, indexes, "Index query parameter not sent")
+	assert.Equal(t, "queryKey=queryData", o.queryData, "Poller modified the original operation")
+}
+
+func TestPoller_Run(t *testing.T) {
+	var mu sync.Mutex
+	count := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		// Change every other poll. If-None-Match is ignored so the ETag comparison detects no change.
+		v := (count + 1) / 2
+		w.Header().Set("ETag", fmt.Sprintf(`"v%d"`, v))
+		fmt.Fprintf(w, `{"version": %d}`, v)
+	}))
+	defer s.Close()
+
+	var d pollData
+	p := NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithResponseTarget(&d)).WithInterval(time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	var seen []int
+	err := p.Run(ctx, func(r *Request) error {
+		seen = append(seen, d.Version)
+		if len(seen) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	assert.Nil(t, err, "Run should return nil when the context is cancelled")
+	assert.Equal(t, []int{1, 2, 3}, seen, "onChange not called for each change")
+
+	stop := fmt.Errorf("stop")
+	err = NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation()).Run(context.Background(), func(r *Request) error {
+		return stop
+	})
+	assert.Equal(t, stop, err, "Error from onChange not returned")
+
+	// Errors are retried until the context is cancelled
+	s.Close()
+	var errs int
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation()).
+		WithErrorBackoff(time.Millisecond, time.Millisecond*10).
+		WithErrorHandler(func(err error) { errs++ }).
+		Run(ctx, nil)
+	assert.Nil(t, err, "Run should return nil when the context is cancelled")
+	assert.True(t, errs > 1, "Poll not retried after an error")
+}
+
+func TestPoller_Run_Backoff(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		times = append(times, time.Now())
+		if len(times) <= 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"version": 1}`)
+	}))
+	defer s.Close()
+
+	var errs []error
+	p := NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation()).
+		WithErrorBackoff(time.Millisecond*10, time.Millisecond*20).
+		WithErrorHandler(func(err error) { errs = append(errs, err) })
+	err := p.Run(context.Background(), func(r *Request) error {
+		return context.Canceled
+	})
+	assert.Equal(t, context.Canceled, err, "Error from onChange not returned")
+	assert.Equal(t, 3, len(errs), "Errors not passed to the error handler")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, times[1].Sub(times[0]) >= time.Millisecond*10, "No backoff after the first error")
+	assert.True(t, times[2].Sub(times[1]) >= time.Millisecond*20, "Backoff not increased after consecutive errors")
+}
+
+func TestPoller_LongPoll_MinInterval(t *testing.T) {
+	defer func(d time.Duration) { minLongPollInterval = d }(minLongPollInterval)
+	minLongPollInterval = time.Millisecond * 50
+	var mu sync.Mutex
+	count := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		count++
+		// The service responds immediately with a new index each time
+		w.Header().Set("X-Consul-Index", fmt.Sprint(count))
+		fmt.Fprint(w, `{"version": 1}`)
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*220)
+	defer cancel()
+	err := NewPoller(NewConfig().WithEndPoint(s.URL), NewGetOperation()).WithLongPoll(time.Minute, "").Run(ctx, nil)
+	assert.Nil(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, count <= 5, "Long polls not limited to the minimum interval, %d polls sent", count)
+}
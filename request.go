@@ -2,13 +2,14 @@ package restclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
-	"io/ioutil"
-	"errors"
-	"fmt"
+	"time"
 )
 
 type RequestBuilder interface {
@@ -23,6 +24,16 @@ type Request struct {
 	HTTPRequest  *http.Request
 	HTTPResponse *http.Response
 	StatusCode   int
+	ResponseBody []byte
+	// The copy of the request that provided the response, starting at 1, when the request was hedged
+	HedgeAttempt int
+	// The attempt that provided the response, starting at 1, when the request was retried
+	RetryAttempt int
+	// Whether the response was served from the Config's Cache
+	FromCache bool
+	// The validators of the response, for use in conditional requests
+	ETag         string
+	LastModified time.Time
 }
 
 // Build a Request and make it ready to send to the ReST service
@@ -66,12 +77,21 @@ func BuildRequest(c *Config, o *Operation) (r *Request, err error) {
 	HTTPReq.URL.RawQuery = o.queryData
 	HTTPReq.Close = true
 	HTTPReq.Header.Set("Content-Type", "application/json")
-	if c.UserId != nil {
-		if c.Password == nil {
-			var password string
-			c.Password = &password
+	for k, v := range o.header {
+		HTTPReq.Header[k] = append([]string(nil), v...)
+	}
+	// Authenticators that sign the body need a digest of it
+	if _, ok := c.authenticator().(contentDigester); ok {
+		setContentDigest(HTTPReq.Header, o.sendData)
+	}
+	// Static credentials do not change so are added now as well as when the request is sent
+	switch a := c.authenticator().(type) {
+	case *BasicAuthenticator:
+		a.Authenticate(context.Background(), HTTPReq)
+	case *BearerAuthenticator:
+		if a.static {
+			a.Authenticate(context.Background(), HTTPReq)
 		}
-		HTTPReq.SetBasicAuth(*c.UserId, *c.Password)
 	}
 
 	r = &Request{
@@ -84,29 +104,164 @@ func BuildRequest(c *Config, o *Operation) (r *Request, err error) {
 
 // Send the request to the ReST service and marshal any response data into the struct defined in the Operation.
 func Send(r *Request) (httpCode *int, err error) {
-	r.HTTPResponse, err = r.Config.HTTPClient.Do(r.HTTPRequest)
+	return SendContext(context.Background(), r)
+}
+
+// Send the request to the ReST service and marshal any response data into the struct defined in the Operation.
+// The call to the ReST service is aborted if the context is cancelled or its deadline passes.
+// If the ReST service responds with 412 Precondition Failed a *PreconditionFailedError is returned.
+func SendContext(ctx context.Context, r *Request) (httpCode *int, err error) {
+	err = r.roundTrip(ctx)
 	if err != nil {
 		code := http.StatusServiceUnavailable
 		httpCode = &code
 		return
 	}
-	r.StatusCode = r.HTTPResponse.StatusCode
 	httpCode = &r.StatusCode
+	if r.StatusCode == http.StatusPreconditionFailed {
+		err = &PreconditionFailedError{StatusCode: r.StatusCode, ETag: r.ETag}
+		return
+	}
+	err = r.decode()
+	return
+}
+
+// roundTrip sends the HTTP request and reads the response body into the Request without decoding it.
+// The body to send is re-read for each call so the same Request can be sent more than once.
+func (r *Request) roundTrip(ctx context.Context) error {
+	r.FromCache = false
+	if r.Config.cache != nil {
+		return r.Config.cache.roundTrip(ctx, r)
+	}
+	return r.sharedRoundTrip(ctx)
+}
+
+// sharedRoundTrip makes the call to the ReST service, sharing it with identical requests in flight if deduplication is enabled.
+func (r *Request) sharedRoundTrip(ctx context.Context) error {
+	if r.Config.flights != nil && r.HTTPRequest.Method == "GET" {
+		return r.Config.flights.do(ctx, r)
+	}
+	return r.networkRoundTrip(ctx)
+}
+
+// networkRoundTrip makes the call to the ReST service, retrying the request if a policy applies to it.
+func (r *Request) networkRoundTrip(ctx context.Context) error {
+	if p := r.retryPolicy(); p != nil {
+		return r.retriedRoundTrip(ctx, p)
+	}
+	return r.hedgedOrSingleRoundTrip(ctx)
+}
+
+// hedgedOrSingleRoundTrip makes one call to the ReST service, hedging the request if a policy applies to it.
+func (r *Request) hedgedOrSingleRoundTrip(ctx context.Context) error {
+	if p := r.hedgePolicy(); p != nil {
+		return r.hedgedRoundTrip(ctx, p)
+	}
+	res := r.attempt(ctx, 1)
+	r.setResult(res)
+	return res.err
+}
+
+// An attemptResult holds the outcome of sending one copy of the HTTP request.
+type attemptResult struct {
+	attempt  int
+	response *http.Response
+	body     []byte
+	latency  time.Duration
+	err      error
+}
 
-	defer r.HTTPResponse.Body.Close()
-	var dec *json.Decoder
-	var bodyBytes []byte
-	if r.HTTPResponse.ContentLength > 0 {
-		bodyBytes, _ = ioutil.ReadAll(io.LimitReader(r.HTTPResponse.Body, r.HTTPResponse.ContentLength))
+// attempt sends one copy of the HTTP request and reads the whole response body.
+// If the ReST service challenges the credentials and the Authenticator can handle it the request is sent once more.
+func (r *Request) attempt(ctx context.Context, n int) (res attemptResult) {
+	res.attempt = n
+	a := r.Config.authenticator()
+	start := time.Now()
+	res.response, res.err = r.send(ctx, a)
+	if res.err != nil {
+		return
+	}
+	if h, ok := a.(ChallengeHandler); ok && isChallenge(res.response) && h.HandleChallenge(res.response) {
+		io.Copy(ioutil.Discard, res.response.Body)
+		res.response.Body.Close()
+		res.response, res.err = r.send(ctx, a)
+		if res.err != nil {
+			return
+		}
+	}
+	defer res.response.Body.Close()
+	if res.response.ContentLength > 0 {
+		res.body, res.err = ioutil.ReadAll(io.LimitReader(res.response.Body, res.response.ContentLength))
 	} else {
-		bodyBytes, _ = ioutil.ReadAll(r.HTTPResponse.Body)
+		res.body, res.err = ioutil.ReadAll(res.response.Body)
 	}
-	dec = json.NewDecoder(bytes.NewReader(bodyBytes))
-	err = dec.Decode(r.Operation.responsePtr)
-	if err != nil {
-		err = errors.New(fmt.Sprintf("Failed to decode response into object: %+v. Response was %v", err, string(bodyBytes)))
+	if ab, ok := a.(abandoner); ok && res.err != nil {
+		ab.abandon(res.response.Request)
+	}
+	if ra, ok := a.(ResponseAuthenticator); ok && res.err == nil {
+		res.err = ra.AuthenticateResponse(res.response)
+	}
+	if res.err == nil && r.Config.responseVerifier != nil {
+		res.err = r.Config.responseVerifier.VerifyResponse(res.response, res.body)
 	}
-	//fmt.Printf("Response: %v\n", string(bodyBytes))
-	//fmt.Printf("marshalled: %+v\n", r.Operation.responsePtr)
+	res.latency = time.Since(start)
 	return
 }
+
+// send makes a single call to the ReST service with a copy of the HTTP request authenticated by the Authenticator, if there is one.
+func (r *Request) send(ctx context.Context, a Authenticator) (*http.Response, error) {
+	if r.Config.rateLimiter != nil {
+		if err := r.Config.rateLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	req := r.HTTPRequest.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	if a != nil {
+		if err := a.Authenticate(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := r.Config.HTTPClient.Do(req)
+	if ab, ok := a.(abandoner); ok && err != nil {
+		ab.abandon(req)
+	}
+	if rd, ok := a.(redactor); ok && resp != nil && resp.Request != nil {
+		rd.redactRequest(resp.Request)
+	}
+	return resp, redactError(a, err)
+}
+
+// setResult records the outcome of an attempt on the Request.
+func (r *Request) setResult(res attemptResult) {
+	r.HTTPResponse = res.response
+	r.ResponseBody = res.body
+	r.ETag = ""
+	r.LastModified = time.Time{}
+	if res.response != nil {
+		r.StatusCode = res.response.StatusCode
+		r.ETag = res.response.Header.Get("ETag")
+		if t, err := http.ParseTime(res.response.Header.Get("Last-Modified")); err == nil {
+			r.LastModified = t
+		}
+	}
+}
+
+// decode marshals the response body into the response target of the Operation, if one has been defined.
+func (r *Request) decode() error {
+	if r.Operation.responsePtr == nil {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(r.ResponseBody))
+	err := dec.Decode(r.Operation.responsePtr)
+	if err != nil {
+		return redactError(r.Config.authenticator(), fmt.Errorf("Failed to decode response into object: %+v. Response was %v", err, string(r.ResponseBody)))
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package restclient
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -271,3 +273,37 @@ func TestSend(t *testing.T) {
 		assert.NotNil(t, err, "Expect to get an error when server not available")
 	}
 }
+
+func TestSend_BearerToken(t *testing.T) {
+	var auth []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+	}))
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithBearerToken("static")
+	r, _ := BuildRequest(c, NewGetOperation())
+	assert.Equal(t, "Bearer static", r.HTTPRequest.Header.Get("Authorization"), "Bearer token not set when building request")
+	Send(r)
+
+	n := 0
+	c = NewConfig().WithEndPoint(s.URL).WithBearerTokenSource(func(ctx context.Context) (string, error) {
+		n++
+		return fmt.Sprintf("dynamic%d", n), nil
+	})
+	r, _ = BuildRequest(c, NewGetOperation())
+	assert.Equal(t, "", r.HTTPRequest.Header.Get("Authorization"), "Token from a source should only be obtained when sending")
+	Send(r)
+	Send(r)
+	assert.Equal(t, []string{"Bearer static", "Bearer dynamic1", "Bearer dynamic2"}, auth, "Authorization headers not as expected")
+
+	srcErr := errors.New("token unavailable")
+	c = NewConfig().WithEndPoint(s.URL).WithBearerTokenSource(func(ctx context.Context) (string, error) {
+		return "", srcErr
+	})
+	r, _ = BuildRequest(c, NewGetOperation())
+	code, err := Send(r)
+	assert.NotNil(t, err, "Expected an error when the token source fails")
+	assert.Equal(t, http.StatusServiceUnavailable, *code)
+	assert.Len(t, auth, 3, "Request should not be sent when the token source fails")
+}
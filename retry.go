@@ -0,0 +1,99 @@
+package restclient
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// Default total number of times a RetryPolicy sends a request, including the first.
+	DefaultRetryMaxAttempts = 3
+	// Default wait before the first retry.
+	DefaultRetryBackoff = time.Millisecond * 100
+	// Default maximum wait between retries.
+	DefaultRetryMaxBackoff = time.Second * 10
+)
+
+// A RetryPolicy sends an idempotent request again if it cannot be sent or the ReST service responds with a 5xx or 429 status.
+// GET and PUT requests are idempotent, as are requests for Operations with an idempotency key.
+// Attempts are made one after another, waiting between them with a backoff that doubles after each attempt.
+type RetryPolicy struct {
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// Create a new RetryPolicy that sends a request up to maxAttempts times in total.
+func NewRetryPolicy(maxAttempts int) *RetryPolicy {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultRetryMaxAttempts
+	}
+	return &RetryPolicy{
+		maxAttempts: maxAttempts,
+		backoff:     DefaultRetryBackoff,
+		maxBackoff:  DefaultRetryMaxBackoff,
+	}
+}
+
+// Wait for the initial duration given before the first retry, doubling the wait after each retry up to the maximum.
+func (p *RetryPolicy) WithBackoff(initial, max time.Duration) *RetryPolicy {
+	p.backoff = initial
+	p.maxBackoff = max
+	return p
+}
+
+// retryable returns whether the outcome of an attempt should be retried.
+func (p *RetryPolicy) retryable(r *Request, err error) bool {
+	if err != nil {
+		return true
+	}
+	return r.StatusCode >= http.StatusInternalServerError || r.StatusCode == http.StatusTooManyRequests
+}
+
+// Retry idempotent requests sent using this config.
+func (c *Config) WithRetry(p *RetryPolicy) *Config {
+	c.retryPolicy = p
+	return c
+}
+
+// Retry this Operation, overriding any RetryPolicy on the Config. Only idempotent Operations are retried.
+func (o *Operation) WithRetry(p *RetryPolicy) *Operation {
+	o.retryPolicy = p
+	return o
+}
+
+// retryPolicy returns the RetryPolicy to use for the Request, or nil if it should not be retried.
+func (r *Request) retryPolicy() *RetryPolicy {
+	if !r.Operation.Idempotent() {
+		return nil
+	}
+	if r.Operation.retryPolicy != nil {
+		return r.Operation.retryPolicy
+	}
+	return r.Config.retryPolicy
+}
+
+// retriedRoundTrip makes the call to the ReST service, repeating it according to the RetryPolicy.
+// The result of the last attempt is recorded.
+func (r *Request) retriedRoundTrip(ctx context.Context, p *RetryPolicy) error {
+	backoff := p.backoff
+	for n := 1; ; n++ {
+		r.RetryAttempt = n
+		err := r.hedgedOrSingleRoundTrip(ctx)
+		if n >= p.maxAttempts || !p.retryable(r, err) || ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		if backoff > p.maxBackoff {
+			backoff = p.maxBackoff
+		}
+	}
+}
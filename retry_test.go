@@ -0,0 +1,80 @@
+package restclient
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Send(t *testing.T) {
+	var calls, cancelled int32
+	s := hedgeServer(&calls, &cancelled, func(n int32) (time.Duration, int) {
+		if n < 3 {
+			return 0, http.StatusServiceUnavailable
+		}
+		return 0, http.StatusOK
+	})
+	defer s.Close()
+
+	var d struct {
+		Call int `json:"call"`
+	}
+	c := NewConfig().WithEndPoint(s.URL).WithRetry(NewRetryPolicy(3).WithBackoff(time.Millisecond*20, time.Second))
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	start := time.Now()
+	code, err := Send(r)
+	assert.Nil(t, err, "Error sending retried request")
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, 3, r.RetryAttempt, "Expected the third attempt to succeed")
+	assert.Equal(t, 3, d.Call, "Response of the last attempt not decoded")
+	assert.True(t, time.Since(start) >= time.Millisecond*60, "Expected a doubling backoff between attempts")
+
+	// The response of the last attempt is returned when all fail
+	status := int32(http.StatusServiceUnavailable)
+	s.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	})
+	atomic.StoreInt32(&calls, 0)
+	r, _ = BuildRequest(c, NewPutOperation())
+	code, err = Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, *code)
+	assert.Equal(t, 3, r.RetryAttempt)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Expected the number of attempts to be limited")
+
+	// Client errors and unsafe methods are not retried
+	for _, o := range []*Operation{NewPostOperation(), NewPatchOperation()} {
+		atomic.StoreInt32(&calls, 0)
+		r, _ = BuildRequest(c, o)
+		Send(r)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "%s should not be retried", o.httpMethod)
+	}
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	atomic.StoreInt32(&calls, 0)
+	r, _ = BuildRequest(c, NewGetOperation())
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusNotFound, *code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "4xx response should not be retried")
+}
+
+func TestRetryPolicy_Cancel(t *testing.T) {
+	var calls, cancelled int32
+	s := hedgeServer(&calls, &cancelled, func(n int32) (time.Duration, int) {
+		return 0, http.StatusTooManyRequests
+	})
+	defer s.Close()
+
+	p := NewRetryPolicy(5).WithBackoff(time.Minute, time.Minute)
+	r, _ := BuildRequest(NewConfig().WithEndPoint(s.URL), NewGetOperation().WithRetry(p))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	SendContext(ctx, r)
+	assert.True(t, time.Since(start) < time.Second*5, "Backoff should end when the context is cancelled")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "No retry expected after the context is cancelled")
+	assert.Equal(t, http.StatusTooManyRequests, r.StatusCode)
+}
@@ -0,0 +1,209 @@
+package restclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// A RevocationMode sets what happens when the revocation status of a certificate cannot be found.
+type RevocationMode string
+
+const (
+	// Connections are allowed unless a certificate is known to be revoked.
+	RevocationSoftFail RevocationMode = "soft-fail"
+	// Connections are refused unless every certificate is known not to be revoked.
+	RevocationHardFail RevocationMode = "hard-fail"
+)
+
+// ErrCertificateRevoked is returned when a certificate presented by the ReST service has been revoked.
+var ErrCertificateRevoked = errors.New("Certificate presented by the ReST service has been revoked")
+
+// ErrRevocationUnknown is returned in hard-fail mode when the revocation status of a certificate presented by the ReST service cannot be found.
+var ErrRevocationUnknown = errors.New("Revocation status of the certificate presented by the ReST service is unknown")
+
+// Check that the certificates presented by the ReST service have not been revoked, using the stapled OCSP response and CRLs from WithCRLFiles.
+func (c *Config) WithRevocationCheck(mode RevocationMode) *Config {
+	c.RevocationMode = mode
+	if mode != RevocationSoftFail && mode != RevocationHardFail {
+		c.configErr = multierror.Append(c.configErr, fmt.Errorf("Unknown revocation mode %q", mode))
+	}
+	c.revocationChecker().mode = mode
+	return c
+}
+
+// Check the certificates presented by the ReST service against the cached CRLs in the PEM or DER format files given, in soft-fail mode by default.
+func (c *Config) WithCRLFiles(paths ...string) *Config {
+	c.CRLFiles = append(c.CRLFiles, paths...)
+	r := c.revocationChecker()
+	for _, p := range paths {
+		if _, err := r.crl(p); err != nil {
+			c.configErr = multierror.Append(c.configErr, err)
+		}
+	}
+	return c
+}
+
+// revocationChecker returns the config's revocationChecker, creating it and setting it to be used for TLS connections if needed.
+func (c *Config) revocationChecker() *revocationChecker {
+	if c.revocation == nil {
+		c.revocation = &revocationChecker{
+			config: c,
+			mode:   RevocationSoftFail,
+			now:    time.Now,
+			crls:   make(map[string]cachedCRL),
+		}
+		c.tlsClientConfig().VerifyConnection = c.verifyConnection
+	}
+	return c.revocation
+}
+
+// verifyConnection is the tls.Config VerifyConnection callback, run for resumed sessions too, checking the chain, pins and revocation.
+func (c *Config) verifyConnection(cs tls.ConnectionState) error {
+	return c.verifyConnectionTo(cs.ServerName, cs)
+}
+
+// verifyConnectionTo verifies the connection to the ReST service with the name given.
+func (c *Config) verifyConnectionTo(name string, cs tls.ConnectionState) error {
+	chains := cs.VerifiedChains
+	if c.certReloader != nil && c.certReloader.verifiesChain() {
+		var err error
+		if chains, err = c.certReloader.verifyChain(name, cs); err != nil {
+			return err
+		}
+	}
+	if len(c.PinnedPublicKeys) > 0 || c.PinOnly {
+		if err := c.verifyPins(cs, chains); err != nil {
+			return err
+		}
+	}
+	if c.revocation != nil {
+		if len(chains) == 0 {
+			// No chain is verified when only pins are trusted
+			var err error
+			if chains, err = c.revocation.presentedChain(cs.PeerCertificates); err != nil {
+				return err
+			}
+		}
+		return c.revocation.check(cs.OCSPResponse, chains)
+	}
+	return nil
+}
+
+// A revocationChecker checks certificates against stapled OCSP responses and cached CRLs.
+type revocationChecker struct {
+	config *Config
+	mode   RevocationMode
+	now    func() time.Time
+	mu     sync.Mutex
+	crls   map[string]cachedCRL
+}
+
+// A cachedCRL is a CRL loaded from a file, and the version of the file it was loaded from.
+type cachedCRL struct {
+	stamp fileStamp
+	crl   *x509.RevocationList
+}
+
+// crl returns the CRL in the file, loading it again only if the file has changed.
+func (r *revocationChecker) crl(path string) (*x509.RevocationList, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := stamp(path)
+	if cc, ok := r.crls[path]; ok && cc.stamp == st {
+		return cc.crl, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("CRL could not be read from file; %v", err)
+	}
+	if block, _ := pem.Decode(b); block != nil && block.Type == "X509 CRL" {
+		b = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, fmt.Errorf("CRL in %s could not be parsed; %v", path, err)
+	}
+	r.crls[path] = cachedCRL{stamp: st, crl: crl}
+	return crl, nil
+}
+
+// presentedChain returns the certificates presented, up to the first that is not signed by the next, as the chain to check.
+func (r *revocationChecker) presentedChain(certs []*x509.Certificate) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		if r.mode == RevocationHardFail {
+			return nil, fmt.Errorf("%w; no certificate was presented", ErrRevocationUnknown)
+		}
+		return nil, nil
+	}
+	n := 1
+	for n < len(certs) && certs[n-1].CheckSignatureFrom(certs[n]) == nil {
+		n++
+	}
+	// In hard-fail mode the chain must end with a self-signed certificate so the status of every other one can be checked
+	last := certs[n-1]
+	if r.mode == RevocationHardFail && (!bytes.Equal(last.RawIssuer, last.RawSubject) ||
+		last.CheckSignature(last.SignatureAlgorithm, last.RawTBSCertificate, last.Signature) != nil) {
+		return nil, fmt.Errorf("%w; the issuer of %s was not presented", ErrRevocationUnknown, last.Subject)
+	}
+	return [][]*x509.Certificate{certs[:n]}, nil
+}
+
+// check returns an error if the certificates in all the chains given cannot be shown not to be revoked.
+func (r *revocationChecker) check(staple []byte, chains [][]*x509.Certificate) error {
+	var err error
+	for _, chain := range chains {
+		// A certificate revoked in one chain may not be needed in another
+		if err = r.checkChain(staple, chain); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (r *revocationChecker) checkChain(staple []byte, chain []*x509.Certificate) error {
+	now := r.now()
+	// The root CA is trusted directly so is not checked
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		var known bool
+		if i == 0 && len(staple) > 0 {
+			resp, err := ocsp.ParseResponseForCert(staple, cert, issuer)
+			// A stale response does not show the current status
+			if err == nil && (resp.NextUpdate.IsZero() || now.Before(resp.NextUpdate)) {
+				switch resp.Status {
+				case ocsp.Revoked:
+					return fmt.Errorf("%w; %s was revoked at %s according to its OCSP response", ErrCertificateRevoked, cert.Subject, resp.RevokedAt.Format(time.RFC3339))
+				case ocsp.Good:
+					known = true
+				}
+			}
+		}
+		for _, path := range r.config.CRLFiles {
+			crl, err := r.crl(path)
+			if err != nil || !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			for _, rc := range crl.RevokedCertificateEntries {
+				if rc.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					return fmt.Errorf("%w; %s was revoked at %s according to the CRL in %s", ErrCertificateRevoked, cert.Subject, rc.RevocationTime.Format(time.RFC3339), path)
+				}
+			}
+			if crl.NextUpdate.IsZero() || now.Before(crl.NextUpdate) {
+				known = true
+			}
+		}
+		if !known && r.mode == RevocationHardFail {
+			return fmt.Errorf("%w; no current OCSP response or CRL for %s", ErrRevocationUnknown, cert.Subject)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package restclient
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// intermediate returns a CA issued by this one.
+func (ca *testCA) intermediate(t *testing.T, name string) *testCA {
+	cert, key := ca.issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	})
+	return &testCA{cert: cert, key: key}
+}
+
+// writeCRL writes a CRL issued by the CA, revoking the certificates given, and returns its path.
+func (ca *testCA) writeCRL(t *testing.T, dir, name string, nextUpdate time.Time, revoked ...*x509.Certificate) string {
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, cert := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("Error creating test CRL: %v", err)
+	}
+	return writePEM(t, dir, name, "X509 CRL", der)
+}
+
+// ocspResponse returns an OCSP response from the CA giving the status of the certificate.
+func (ca *testCA) ocspResponse(t *testing.T, cert *x509.Certificate, status int, nextUpdate time.Time) []byte {
+	b, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   nextUpdate,
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, ca.key)
+	if err != nil {
+		t.Fatalf("Error creating test OCSP response: %v", err)
+	}
+	return b
+}
+
+func TestConfig_WithRevocationCheck(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	root := newTestCA(t, "Test Root CA")
+	inter := root.intermediate(t, "Test Intermediate CA")
+	serverCert := inter.serverCert(t)
+	leaf := serverCert.Leaf
+	// A CA with the same name as the intermediate but a different key
+	forger := newTestCA(t, "Test Intermediate CA")
+
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Minute)
+	rootCRL := root.writeCRL(t, dir, "root.crl", future)
+	interCRL := inter.writeCRL(t, dir, "inter.crl", future)
+	revokedInter := root.writeCRL(t, dir, "root-revoked.crl", future, inter.cert)
+	revokedLeaf := inter.writeCRL(t, dir, "inter-revoked.crl", future, leaf)
+	staleCRL := inter.writeCRL(t, dir, "inter-stale.crl", past)
+	forgedCRL := forger.writeCRL(t, dir, "forged.crl", future, leaf)
+	good := inter.ocspResponse(t, leaf, ocsp.Good, future)
+	revoked := inter.ocspResponse(t, leaf, ocsp.Revoked, future)
+	stale := inter.ocspResponse(t, leaf, ocsp.Good, past)
+	forgedOCSP := forger.ocspResponse(t, leaf, ocsp.Good, future)
+
+	var tests = []struct {
+		name   string
+		mode   RevocationMode
+		staple []byte
+		crls   []string
+		err    error
+	}{
+		{"Soft-fail without revocation information", RevocationSoftFail, nil, nil, nil},
+		{"Hard-fail without revocation information", RevocationHardFail, nil, nil, ErrRevocationUnknown},
+		{"Hard-fail with OCSP and CRL", RevocationHardFail, good, []string{rootCRL}, nil},
+		{"Hard-fail with CRLs", RevocationHardFail, nil, []string{rootCRL, interCRL}, nil},
+		{"Hard-fail without intermediate CRL", RevocationHardFail, good, nil, ErrRevocationUnknown},
+		{"Revoked OCSP response", RevocationSoftFail, revoked, nil, ErrCertificateRevoked},
+		{"Revoked by CRL", RevocationSoftFail, good, []string{revokedLeaf}, ErrCertificateRevoked},
+		{"Intermediate revoked by CRL", RevocationSoftFail, good, []string{revokedInter}, ErrCertificateRevoked},
+		{"Stale OCSP response", RevocationHardFail, stale, []string{rootCRL}, ErrRevocationUnknown},
+		{"Stale CRL", RevocationHardFail, nil, []string{rootCRL, staleCRL}, ErrRevocationUnknown},
+		{"Forged CRL", RevocationSoftFail, nil, []string{forgedCRL}, nil},
+		{"Forged OCSP response", RevocationHardFail, forgedOCSP, []string{rootCRL}, ErrRevocationUnknown},
+	}
+	for _, test := range tests {
+		cert := serverCert
+		cert.OCSPStaple = test.staple
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Field": "value"}`)
+		}))
+		s.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		s.StartTLS()
+
+		c := NewConfig().WithEndPoint(s.URL).WithCACert(root.cert).WithCRLFiles(test.crls...).WithRevocationCheck(test.mode)
+		assert.Nil(t, c.configErr, test.name)
+		_, err := sendField(c)
+		if test.err == nil {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.True(t, errors.Is(err, test.err), "%s: expected %v, got %v", test.name, test.err, err)
+		}
+		s.Close()
+	}
+
+	c := NewConfig().WithRevocationCheck("strict")
+	assert.NotNil(t, c.configErr, "Unknown revocation mode did not create an error in the configuration")
+	c = NewConfig().WithCRLFiles(filepath.Join(dir, "missing.crl"))
+	assert.NotNil(t, c.configErr, "Missing CRL file did not create an error in the configuration")
+}
+
+func TestConfig_WithCRLFiles_Cache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newTLSServer(t, ca)
+	defer s.Close()
+	leaf, _ := x509.ParseCertificate(s.TLS.Certificates[0].Certificate[0])
+	crlPath := ca.writeCRL(t, dir, "ca.crl", time.Now().Add(time.Hour))
+
+	c := NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithCRLFiles(crlPath).WithRevocationCheck(RevocationHardFail)
+	_, err := sendField(c)
+	assert.Nil(t, err)
+	crl, _ := c.revocation.crl(crlPath)
+	_, err = sendField(c)
+	assert.Nil(t, err)
+	cached, _ := c.revocation.crl(crlPath)
+	assert.True(t, crl == cached, "CRL not cached")
+
+	// An updated CRL is loaded again
+	ca.writeCRL(t, dir, "ca.crl", time.Now().Add(time.Hour), leaf)
+	rotated(t, crlPath, 1)
+	_, err = sendField(c)
+	assert.True(t, errors.Is(err, ErrCertificateRevoked), "Updated CRL not loaded: %v", err)
+}
+
+func TestConfig_WithRevocationCheck_Reload(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	s := newTLSServer(t, ca)
+	defer s.Close()
+	leaf, _ := x509.ParseCertificate(s.TLS.Certificates[0].Certificate[0])
+	caPath := writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.cert.Raw)
+	crlPath := ca.writeCRL(t, dir, "ca.crl", time.Now().Add(time.Hour), leaf)
+
+	// Revocation is checked against the chains verified by the certificate reloader
+	c := NewConfig().WithEndPoint(s.URL).WithCAFilePath(caPath).WithCertificateReload(time.Minute).WithCRLFiles(crlPath)
+	_, err := sendField(c)
+	assert.True(t, errors.Is(err, ErrCertificateRevoked), "Revocation not checked when the certificate chain is reloaded: %v", err)
+}
+
+func TestConfig_WithRevocationCheck_PinOnly(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	serverCert := ca.serverCert(t)
+	leaf := serverCert.Leaf
+	crlPath := ca.writeCRL(t, dir, "ca.crl", time.Now().Add(time.Hour))
+	revokedLeaf := ca.writeCRL(t, dir, "ca-revoked.crl", time.Now().Add(time.Hour), leaf)
+	leafOnly := serverCert
+	leafOnly.Certificate = serverCert.Certificate[:1]
+
+	var tests = []struct {
+		name string
+		cert tls.Certificate
+		mode RevocationMode
+		crl  string
+		err  error
+	}{
+		{"Hard-fail with CRL", serverCert, RevocationHardFail, crlPath, nil},
+		{"Hard-fail revoked by CRL", serverCert, RevocationHardFail, revokedLeaf, ErrCertificateRevoked},
+		{"Soft-fail revoked by CRL", serverCert, RevocationSoftFail, revokedLeaf, ErrCertificateRevoked},
+		{"Hard-fail without the issuer", leafOnly, RevocationHardFail, revokedLeaf, ErrRevocationUnknown},
+		{"Soft-fail without the issuer", leafOnly, RevocationSoftFail, revokedLeaf, nil},
+	}
+	for _, test := range tests {
+		s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"Field": "value"}`)
+		}))
+		s.TLS = &tls.Config{Certificates: []tls.Certificate{test.cert}}
+		s.StartTLS()
+
+		// The certificates presented are checked when no chain is verified
+		c := NewConfig().WithEndPoint(s.URL).WithPinnedPublicKeys(PublicKeyPin(leaf), PublicKeyPin(ca.cert)).WithPinOnlyVerification().
+			WithCRLFiles(test.crl).WithRevocationCheck(test.mode)
+		_, err := sendField(c)
+		if test.err == nil {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.True(t, errors.Is(err, test.err), "%s: expected %v, got %v", test.name, test.err, err)
+		}
+		s.Close()
+	}
+}
+
+func TestConfig_Load_Revocation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "Test CA")
+	crlPath := ca.writeCRL(t, dir, "ca.crl", time.Now().Add(time.Hour))
+	testConfigFile := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(testConfigFile, []byte(fmt.Sprintf(`{
+		"EndPoint": "http://testurl",
+		"CRLFiles": [%q],
+		"RevocationMode": "hard-fail"
+	}`, crlPath)), 0600)
+	c := Load(testConfigFile)
+	assert.Nil(t, c.Validate())
+	assert.Equal(t, []string{crlPath}, c.CRLFiles)
+	assert.Equal(t, RevocationHardFail, c.revocation.mode)
+	assert.NotNil(t, c.tlsClientConfig().VerifyConnection)
+
+	ioutil.WriteFile(testConfigFile, []byte(`{"EndPoint": "http://testurl", "RevocationMode": "sometimes"}`), 0600)
+	assert.NotNil(t, Load(testConfigFile).Validate(), "Unknown revocation mode should make the configuration invalid")
+}
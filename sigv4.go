@@ -0,0 +1,224 @@
+package restclient
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	// Payload hash used in place of the hash of the body in unsigned payload mode
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// AWSCredentials are the credentials used to sign requests to AWS. SessionToken is only needed for temporary credentials.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// An AWSCredentialsSource provides the credentials each time a request is signed, so temporary credentials can be refreshed.
+type AWSCredentialsSource func(ctx context.Context) (AWSCredentials, error)
+
+// A SigV4Authenticator signs requests using AWS Signature Version 4.
+type SigV4Authenticator struct {
+	region          string
+	service         string
+	credentials     AWSCredentialsSource
+	unsignedPayload bool
+	headers         map[string]bool
+	now             func() time.Time
+}
+
+// Create a new SigV4Authenticator that signs requests for the AWS region and service given, using static credentials.
+func NewSigV4Authenticator(region, service, accessKeyID, secretAccessKey, sessionToken string) *SigV4Authenticator {
+	creds := AWSCredentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+	}
+	return NewSigV4AuthenticatorWithSource(region, service, func(ctx context.Context) (AWSCredentials, error) {
+		return creds, nil
+	})
+}
+
+// Create a new SigV4Authenticator that signs requests for the AWS region and service given, using credentials from the source.
+func NewSigV4AuthenticatorWithSource(region, service string, s AWSCredentialsSource) *SigV4Authenticator {
+	return &SigV4Authenticator{
+		region:      region,
+		service:     service,
+		credentials: s,
+		headers:     make(map[string]bool),
+		now:         time.Now,
+	}
+}
+
+// Do not sign the body of requests. The payload hash is replaced by UNSIGNED-PAYLOAD, as supported by Amazon S3.
+func (a *SigV4Authenticator) WithUnsignedPayload() *SigV4Authenticator {
+	a.unsignedPayload = true
+	return a
+}
+
+// Sign the headers given, if they are present, in addition to those signed by default.
+func (a *SigV4Authenticator) WithSignedHeaders(headers ...string) *SigV4Authenticator {
+	for _, h := range headers {
+		a.headers[strings.ToLower(h)] = true
+	}
+	return a
+}
+
+// Authenticate signs the request, setting the X-Amz-Date, X-Amz-Security-Token, X-Amz-Content-Sha256 and Authorization headers as needed.
+func (a *SigV4Authenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	creds, err := a.credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("AWS credentials could not be obtained; %v", err)
+	}
+	t := a.now().UTC()
+	date := t.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", date)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	} else {
+		req.Header.Del("X-Amz-Security-Token")
+	}
+	payloadHash := sigV4UnsignedPayload
+	if !a.unsignedPayload {
+		body, err := requestBody(req)
+		if err != nil {
+			return fmt.Errorf("Request body could not be read to sign; %v", err)
+		}
+		h := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(h[:])
+	}
+	if a.unsignedPayload || a.service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := a.canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		a.canonicalPath(req.URL),
+		canonicalQuery(req.URL.RawQuery),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date[:8], a.region, a.service, "aws4_request"}, "/")
+	crh := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{sigV4Algorithm, date, scope, hex.EncodeToString(crh[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date[:8])
+	for _, s := range []string{a.region, a.service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", sigV4Algorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalHeaders returns the names of the headers to sign and their canonical form.
+func (a *SigV4Authenticator) canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string][]string{"host": {req.Host}}
+	if req.Host == "" {
+		values["host"] = []string{req.URL.Host}
+	}
+	for k, v := range req.Header {
+		n := strings.ToLower(k)
+		if n == "authorization" {
+			continue
+		}
+		if strings.HasPrefix(n, "x-amz-") || n == "content-type" || n == "content-md5" || a.headers[n] {
+			values[n] = v
+		}
+	}
+	names := make([]string, 0, len(values))
+	for n := range values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, n := range names {
+		vs := make([]string, len(values[n]))
+		for i, v := range values[n] {
+			// Trim and collapse sequential spaces
+			vs[i] = strings.Join(strings.Fields(v), " ")
+		}
+		b.WriteString(n)
+		b.WriteString(":")
+		b.WriteString(strings.Join(vs, ","))
+		b.WriteString("\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// canonicalPath returns the URI encoded path. Except for Amazon S3, the path is normalized and each segment encoded twice.
+func (a *SigV4Authenticator) canonicalPath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	if a.service == "s3" {
+		return awsURIEncode(u.Path, false)
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return awsURIEncode(clean, false)
+}
+
+// canonicalQuery returns the query string with each name and value URI encoded, sorted by name then value.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	q, _ := url.ParseQuery(rawQuery)
+	var params [][2]string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, [2]string{awsURIEncode(k, true), awsURIEncode(v, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	encoded := make([]string, len(params))
+	for i, p := range params {
+		encoded[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// awsURIEncode percent-encodes every byte except the unreserved characters of RFC 3986, leaving slashes as they are unless encodeSlash is true.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,105 @@
+package restclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Credentials and time used by the AWS Signature Version 4 test suite.
+const (
+	sigV4TestAccessKeyID     = "AKIDEXAMPLE"
+	sigV4TestSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func sigV4TestAuthenticator() *SigV4Authenticator {
+	a := NewSigV4Authenticator("us-east-1", "service", sigV4TestAccessKeyID, sigV4TestSecretAccessKey, "")
+	a.now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	}
+	return a
+}
+
+func TestSigV4Authenticator_TestSuite(t *testing.T) {
+	// Cases from the AWS Signature Version 4 test suite
+	var tests = []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		signed      string
+		signature   string
+	}{
+		{"get-vanilla", "GET", "/", "", "", "host;x-amz-date", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", "", "", "host;x-amz-date", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-vanilla-query-order-value", "GET", "/?Param1=value2&Param1=value1", "", "", "host;x-amz-date", "5772eed61e12b33fae39ee5e7012498b51d56abc0abb7c60486157bd471c4694"},
+		{"get-vanilla-utf8-query", "GET", "/?%E1%88%B4=bar", "", "", "host;x-amz-date", "2cdec8eed098649ff3a119c94853b13c643bcf08f8b0a1d91e12c9027818dd04"},
+		{"get-relative-relative", "GET", "/example1/example2/../..", "", "", "host;x-amz-date", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", "POST", "/", "", "", "host;x-amz-date", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-x-www-form-urlencoded", "POST", "/", "application/x-www-form-urlencoded", "Param1=value1", "content-type;host;x-amz-date", "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, "https://example.amazonaws.com"+test.url, strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		err := sigV4TestAuthenticator().Authenticate(context.Background(), req)
+		assert.Nil(t, err)
+		assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders="+test.signed+", Signature="+test.signature,
+			req.Header.Get("Authorization"), "Authorization header not as expected for %s", test.name)
+		assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	}
+}
+
+func TestSigV4Authenticator_SessionTokenAndUnsignedPayload(t *testing.T) {
+	a := sigV4TestAuthenticator()
+	a.credentials = func(ctx context.Context) (AWSCredentials, error) {
+		return AWSCredentials{AccessKeyID: sigV4TestAccessKeyID, SecretAccessKey: sigV4TestSecretAccessKey, SessionToken: "token"}, nil
+	}
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	a.Authenticate(context.Background(), req)
+	assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,", "Session token should be signed")
+
+	a.WithUnsignedPayload().WithSignedHeaders("X-Custom")
+	req, _ = http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/a%20key", strings.NewReader("data"))
+	req.Header.Set("X-Custom", "  a   b ")
+	a.Authenticate(context.Background(), req)
+	assert.Equal(t, sigV4UnsignedPayload, req.Header.Get("X-Amz-Content-Sha256"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token;x-custom,")
+
+	_, canonical := a.canonicalHeaders(req)
+	assert.Contains(t, canonical, "x-custom:a b\n", "Header value not trimmed")
+	assert.Equal(t, "/a%2520key", a.canonicalPath(req.URL), "Path should be encoded twice for services other than S3")
+	a.service = "s3"
+	assert.Equal(t, "/a%20key", a.canonicalPath(req.URL), "Path should be encoded once for S3")
+}
+
+func TestSend_SigV4(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer s.Close()
+
+	a := NewSigV4Authenticator("eu-west-1", "s3", sigV4TestAccessKeyID, sigV4TestSecretAccessKey, "")
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(a)
+	r, _ := BuildRequest(c, NewPutOperation().WithPath("/bucket/key").WithQueryDataString("b=2&a=1").WithBodyDataString(`{"a": 1}`))
+	_, err := Send(r)
+	assert.Nil(t, err)
+	h := sha256.Sum256([]byte(`{"a": 1}`))
+	assert.Equal(t, hex.EncodeToString(h[:]), got.Header.Get("X-Amz-Content-Sha256"), "Payload hash not sent for S3")
+	assert.Equal(t, `{"a": 1}`, string(body))
+	assert.True(t, strings.HasPrefix(got.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"), "Request not signed")
+	assert.Contains(t, got.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date,")
+}
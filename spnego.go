@@ -0,0 +1,254 @@
+package restclient
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long the details of a request are kept for the mutual authentication of its response.
+const spnegoContextTimeout = 5 * time.Minute
+
+// ErrMutualAuthentication is returned when the response to a request authenticated with Kerberos does not prove the identity of the ReST service.
+var ErrMutualAuthentication = errors.New("Kerberos mutual authentication of the ReST service failed")
+
+// An SPNEGOAuthenticator authenticates requests with Kerberos using SPNEGO, sending an Authorization: Negotiate header.
+// A service ticket is obtained for the service principal HTTP/host, where host is the host name of the ReST service, unless another SPN is given.
+//
+// Mutual authentication is requested. If the ReST service's response includes an AP_REP token it is verified,
+// and the response is rejected with an error wrapping ErrMutualAuthentication if it was not made by the service the ticket was for.
+type SPNEGOAuthenticator struct {
+	client        *client.Client
+	spn           string
+	requireMutual bool
+	mu            sync.Mutex
+	contexts      map[string]spnegoContext
+	// serviceTicket gets a service ticket from the KDC, it is replaced in tests
+	serviceTicket func(spn string) (messages.Ticket, types.EncryptionKey, error)
+}
+
+// An spnegoContext holds what is needed to verify the AP_REP in the response to a request.
+type spnegoContext struct {
+	key   types.EncryptionKey
+	ctime time.Time
+	cusec int
+	sent  time.Time
+}
+
+// Create a new SPNEGOAuthenticator that gets service tickets using the gokrb5 Kerberos client given.
+func NewSPNEGOAuthenticator(cl *client.Client) *SPNEGOAuthenticator {
+	return &SPNEGOAuthenticator{
+		client:        cl,
+		contexts:      make(map[string]spnegoContext),
+		serviceTicket: cl.GetServiceTicket,
+	}
+}
+
+// Create a new SPNEGOAuthenticator for the user given, logging in with the key in the keytab file.
+// The Kerberos configuration is loaded from the krb5.conf file given.
+func NewSPNEGOAuthenticatorWithKeytab(username, realm, keytabPath, krb5ConfPath string) (*SPNEGOAuthenticator, error) {
+	cfg, err := config.Load(krb5ConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("Kerberos configuration could not be loaded; %v", err)
+	}
+	kt, err := keytab.Load(keytabPath)
+	if err != nil {
+		return nil, fmt.Errorf("Keytab could not be loaded; %v", err)
+	}
+	return NewSPNEGOAuthenticator(client.NewWithKeytab(username, realm, kt, cfg, client.DisablePAFXFAST(true))), nil
+}
+
+// Create a new SPNEGOAuthenticator using the tickets in a credential cache file, such as one created by kinit.
+// The Kerberos configuration is loaded from the krb5.conf file given.
+func NewSPNEGOAuthenticatorWithCCache(ccachePath, krb5ConfPath string) (*SPNEGOAuthenticator, error) {
+	cfg, err := config.Load(krb5ConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("Kerberos configuration could not be loaded; %v", err)
+	}
+	cc, err := credentials.LoadCCache(ccachePath)
+	if err != nil {
+		return nil, fmt.Errorf("Credential cache could not be loaded; %v", err)
+	}
+	cl, err := client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+	if err != nil {
+		return nil, fmt.Errorf("Kerberos client could not be created from the credential cache; %v", err)
+	}
+	return NewSPNEGOAuthenticator(cl), nil
+}
+
+// Get service tickets for the service principal name given rather than HTTP/host.
+func (a *SPNEGOAuthenticator) WithSPN(spn string) *SPNEGOAuthenticator {
+	a.spn = spn
+	return a
+}
+
+// Reject responses that do not include an AP_REP token proving the identity of the ReST service.
+func (a *SPNEGOAuthenticator) WithMutualAuthenticationRequired() *SPNEGOAuthenticator {
+	a.requireMutual = true
+	return a
+}
+
+// Authenticate gets a service ticket for the ReST service and sets the Negotiate authorization header on the request.
+func (a *SPNEGOAuthenticator) Authenticate(ctx context.Context, req *http.Request) error {
+	spn := a.spn
+	if spn == "" {
+		spn = "HTTP/" + strings.ToLower(req.URL.Hostname())
+	}
+	tkt, key, err := a.serviceTicket(spn)
+	if err != nil {
+		return fmt.Errorf("Kerberos service ticket for %s could not be obtained; %v", spn, err)
+	}
+	mt, err := spnego.NewKRB5TokenAPREQ(a.client, tkt, key,
+		[]int{gssapi.ContextFlagInteg, gssapi.ContextFlagConf, gssapi.ContextFlagMutual}, []int{flags.APOptionMutualRequired})
+	if err != nil {
+		return fmt.Errorf("Kerberos AP_REQ could not be created; %v", err)
+	}
+	// The time in the authenticator is returned by the ReST service for mutual authentication
+	if err := mt.APReq.DecryptAuthenticator(key); err != nil {
+		return fmt.Errorf("Kerberos AP_REQ could not be created; %v", err)
+	}
+	mtb, err := mt.Marshal()
+	if err != nil {
+		return fmt.Errorf("Kerberos AP_REQ could not be marshalled; %v", err)
+	}
+	st := spnego.SPNEGOToken{
+		Init: true,
+		NegTokenInit: spnego.NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+			MechTokenBytes: mtb,
+		},
+	}
+	b, err := st.Marshal()
+	if err != nil {
+		return fmt.Errorf("SPNEGO token could not be marshalled; %v", err)
+	}
+	h := "Negotiate " + base64.StdEncoding.EncodeToString(b)
+	req.Header.Set("Authorization", h)
+
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, c := range a.contexts {
+		// Requests that were never answered
+		if now.Sub(c.sent) > spnegoContextTimeout {
+			delete(a.contexts, k)
+		}
+	}
+	a.contexts[h] = spnegoContext{
+		key:   key,
+		ctime: mt.APReq.Authenticator.CTime,
+		cusec: mt.APReq.Authenticator.Cusec,
+		sent:  now,
+	}
+	return nil
+}
+
+// AuthenticateResponse verifies the AP_REP token in the response, if there is one, proving the identity of the ReST service.
+// If mutual authentication is required a response to a request that was not authenticated, such as after a redirect, is rejected.
+func (a *SPNEGOAuthenticator) AuthenticateResponse(resp *http.Response) error {
+	var c spnegoContext
+	ok := false
+	if resp.Request != nil {
+		h := resp.Request.Header.Get("Authorization")
+		a.mu.Lock()
+		c, ok = a.contexts[h]
+		delete(a.contexts, h)
+		// Requests that were redirected
+		for prev := resp.Request.Response; prev != nil && prev.Request != nil; prev = prev.Request.Response {
+			delete(a.contexts, prev.Request.Header.Get("Authorization"))
+		}
+		a.mu.Unlock()
+	}
+	if isChallenge(resp) {
+		return nil
+	}
+	if !ok {
+		if a.requireMutual {
+			return fmt.Errorf("%w; the request for the response was not authenticated", ErrMutualAuthentication)
+		}
+		return nil
+	}
+	var token string
+	for _, ch := range parseChallenges(resp.Header.Values("WWW-Authenticate")) {
+		if strings.EqualFold(ch.scheme, "Negotiate") {
+			token = ch.token
+		}
+	}
+	if token == "" {
+		if a.requireMutual {
+			return fmt.Errorf("%w; the response has no Negotiate token", ErrMutualAuthentication)
+		}
+		return nil
+	}
+	if err := c.verify(token, a.requireMutual); err != nil {
+		return fmt.Errorf("%w; %v", ErrMutualAuthentication, err)
+	}
+	return nil
+}
+
+// abandon forgets the security context of a request that did not receive a response.
+func (a *SPNEGOAuthenticator) abandon(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.contexts, req.Header.Get("Authorization"))
+}
+
+// verify checks the SPNEGO response token is accepted and any AP_REP it holds matches the request's authenticator.
+func (c spnegoContext) verify(token string, required bool) error {
+	b, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return errors.New("Negotiate token is not valid base64")
+	}
+	var st spnego.SPNEGOToken
+	if err := st.Unmarshal(b); err != nil || !st.Resp {
+		return fmt.Errorf("Negotiate token is not a SPNEGO response; %v", err)
+	}
+	if spnego.NegState(st.NegTokenResp.NegState) != spnego.NegStateAcceptCompleted {
+		return errors.New("SPNEGO negotiation was not completed")
+	}
+	if len(st.NegTokenResp.ResponseToken) == 0 {
+		if required {
+			return errors.New("SPNEGO response has no AP_REP")
+		}
+		return nil
+	}
+	var mt spnego.KRB5Token
+	if err := mt.Unmarshal(st.NegTokenResp.ResponseToken); err != nil {
+		return err
+	}
+	if mt.IsKRBError() {
+		return fmt.Errorf("ReST service returned a Kerberos error; %s", mt.KRBError.Error())
+	}
+	if !mt.IsAPRep() {
+		return errors.New("SPNEGO response has no AP_REP")
+	}
+	eb, err := crypto.DecryptEncPart(mt.APRep.EncPart, c.key, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("AP_REP could not be decrypted; %v", err)
+	}
+	var ep messages.EncAPRepPart
+	if err := ep.Unmarshal(eb); err != nil {
+		return err
+	}
+	if !ep.CTime.Equal(c.ctime) || ep.Cusec != c.cusec {
+		return errors.New("AP_REP does not match the request's authenticator")
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package restclient
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/service"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRealm = "EXAMPLE.COM"
+
+// A kerberosTestRealm stands in for a KDC, issuing service tickets encrypted with the keys in its keytab, and for a ReST service accepting them.
+type kerberosTestRealm struct {
+	kt *keytab.Keytab
+	mu sync.Mutex
+	// SPNs tickets have been requested for
+	spns []string
+	// How the acceptor replies: "mutual" with an AP_REP, "forged" with an AP_REP for another authenticator or "none" without one
+	reply string
+	// Clients authenticated by the acceptor
+	clients []string
+}
+
+func newKerberosTestRealm(t *testing.T, spns ...string) *kerberosTestRealm {
+	kt := keytab.New()
+	for _, spn := range spns {
+		if err := kt.AddEntry(spn, testRealm, "service password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatalf("Error creating test keytab: %v", err)
+		}
+	}
+	return &kerberosTestRealm{kt: kt, reply: "mutual"}
+}
+
+// authenticator returns an SPNEGOAuthenticator for testuser that gets its tickets from the test realm.
+func (k *kerberosTestRealm) authenticator() *SPNEGOAuthenticator {
+	a := NewSPNEGOAuthenticator(client.NewWithPassword("testuser", testRealm, "password", config.New()))
+	a.serviceTicket = func(spn string) (messages.Ticket, types.EncryptionKey, error) {
+		k.mu.Lock()
+		k.spns = append(k.spns, spn)
+		k.mu.Unlock()
+		now := time.Now().UTC()
+		return messages.NewTicket(types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, "testuser"), testRealm,
+			types.NewPrincipalName(nametype.KRB_NT_SRV_INST, spn), testRealm, types.NewKrbFlags(), k.kt,
+			etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	}
+	return a
+}
+
+// ServeHTTP accepts requests with a valid AP_REQ and replies as configured.
+func (k *kerberosTestRealm) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Negotiate ") {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(h, "Negotiate "))
+	var st spnego.SPNEGOToken
+	var mt spnego.KRB5Token
+	if err := st.Unmarshal(b); err != nil || !st.Init || mt.Unmarshal(st.NegTokenInit.MechTokenBytes) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ok, creds, err := service.VerifyAPREQ(&mt.APReq, service.NewSettings(k.kt, service.DecodePAC(false)))
+	if !ok || err != nil {
+		w.Header().Set("WWW-Authenticate", "Negotiate oQcwBaADCgEC")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	k.mu.Lock()
+	k.clients = append(k.clients, creds.UserName()+"@"+creds.Domain())
+	reply := k.reply
+	k.mu.Unlock()
+	resp := spnego.NegTokenResp{
+		NegState:      asn1.Enumerated(spnego.NegStateAcceptCompleted),
+		SupportedMech: gssapi.OIDKRB5.OID(),
+	}
+	if reply != "none" {
+		ep := messages.EncAPRepPart{CTime: mt.APReq.Authenticator.CTime, Cusec: mt.APReq.Authenticator.Cusec}
+		if reply == "forged" {
+			ep.Cusec++
+		}
+		resp.ResponseToken = apRepToken(mt.APReq.Ticket.DecryptedEncPart.Key, ep)
+	}
+	rb, _ := resp.Marshal()
+	w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(rb))
+	fmt.Fprint(w, `{"Field": "value"}`)
+}
+
+// apRepToken returns a KRB5 mech token holding an AP_REP with the encrypted part given.
+func apRepToken(key types.EncryptionKey, ep messages.EncAPRepPart) []byte {
+	eb, _ := asn1.Marshal(ep)
+	ed, _ := crypto.GetEncryptedData(asn1tools.AddASNAppTag(eb, asnAppTag.EncAPRepPart), key, keyusage.AP_REP_ENCPART, 0)
+	rb, _ := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: ed})
+	b, _ := asn1.Marshal(gssapi.OIDKRB5.OID())
+	b = append(b, 0x02, 0x00)
+	b = append(b, asn1tools.AddASNAppTag(rb, asnAppTag.APREP)...)
+	return asn1tools.AddASNAppTag(b, 0)
+}
+
+func TestSPNEGOAuthenticator_Send(t *testing.T) {
+	k := newKerberosTestRealm(t, "HTTP/127.0.0.1")
+	s := httptest.NewServer(k)
+	defer s.Close()
+	var d struct {
+		Field string
+	}
+
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(k.authenticator().WithMutualAuthenticationRequired())
+	r, _ := BuildRequest(c, NewGetOperation().WithResponseTarget(&d))
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, "value", d.Field)
+	assert.Equal(t, []string{"HTTP/127.0.0.1"}, k.spns, "Service ticket not requested for HTTP/host")
+	assert.Equal(t, []string{"testuser@" + testRealm}, k.clients, "Client not authenticated by the service")
+
+	// An AP_REP that does not match the request does not authenticate the service
+	k.reply = "forged"
+	_, err = Send(r)
+	assert.True(t, errors.Is(err, ErrMutualAuthentication), "Forged AP_REP should be rejected")
+
+	// Without an AP_REP the response is only accepted if mutual authentication is not required
+	k.reply = "none"
+	_, err = Send(r)
+	assert.True(t, errors.Is(err, ErrMutualAuthentication), "Response without an AP_REP should be rejected when mutual authentication is required")
+	c.WithAuthenticator(k.authenticator())
+	code, err = Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+}
+
+func TestSPNEGOAuthenticator_SPN(t *testing.T) {
+	k := newKerberosTestRealm(t, "HTTP/rest.example.com")
+	s := httptest.NewServer(k)
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).WithAuthenticator(k.authenticator().WithSPN("HTTP/rest.example.com"))
+	r, _ := BuildRequest(c, NewGetOperation())
+	code, err := Send(r)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, *code)
+	assert.Equal(t, []string{"HTTP/rest.example.com"}, k.spns, "SPN given not used")
+
+	// A ticket encrypted with a key other than the service's is rejected
+	other := newKerberosTestRealm(t)
+	other.kt.AddEntry("HTTP/rest.example.com", testRealm, "other password", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	c.WithAuthenticator(other.authenticator().WithSPN("HTTP/rest.example.com"))
+	code, _ = Send(r)
+	assert.Equal(t, http.StatusUnauthorized, *code, "Ticket not encrypted with the service's key should not be accepted")
+	assert.Equal(t, 1, len(k.clients))
+}
+
+func TestSPNEGOAuthenticator_Unauthenticated(t *testing.T) {
+	k := newKerberosTestRealm(t, "HTTP/127.0.0.1")
+	s := httptest.NewServer(k)
+	defer s.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+	// The redirect to another host drops the Authorization header
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(plain.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	a := k.authenticator().WithSPN("HTTP/127.0.0.1").WithMutualAuthenticationRequired()
+	c := NewConfig().WithEndPoint(redirect.URL).WithAuthenticator(a)
+	r, _ := BuildRequest(c, NewGetOperation())
+	_, err := Send(r)
+	assert.True(t, errors.Is(err, ErrMutualAuthentication), "Response to an unauthenticated request should be rejected when mutual authentication is required")
+	a.mu.Lock()
+	assert.Len(t, a.contexts, 0, "Security context of the redirected request not removed")
+	a.mu.Unlock()
+
+	// The security context of a request that fails is not kept
+	s.Close()
+	c = NewConfig().WithEndPoint(s.URL).WithAuthenticator(a)
+	r, _ = BuildRequest(c, NewGetOperation())
+	_, err = Send(r)
+	assert.NotNil(t, err)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	assert.Len(t, a.contexts, 0, "Security context of the failed request not removed")
+}
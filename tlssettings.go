@@ -0,0 +1,155 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	multierror "github.com/hashicorp/go-multierror"
+	"strings"
+)
+
+// TLSSettings are the settings of TLS connections to the ReST service when the config is loaded from a JSON file.
+// Versions are given as "1.2" or "1.3", cipher suites by their names such as "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+// and curves as "X25519", "P256", "P384" or "P521".
+type TLSSettings struct {
+	MinVersion       string   `json:"MinVersion,omitempty"`
+	MaxVersion       string   `json:"MaxVersion,omitempty"`
+	CipherSuites     []string `json:"CipherSuites,omitempty"`
+	CurvePreferences []string `json:"CurvePreferences,omitempty"`
+	ServerName       string   `json:"ServerName,omitempty"`
+	ALPNProtocols    []string `json:"ALPNProtocols,omitempty"`
+	SessionCacheSize int      `json:"SessionCacheSize,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// Limit the TLS versions used to connect to the ReST service, for example tls.VersionTLS12 and tls.VersionTLS13.
+// Zero leaves the bound as it is, which is the Go default unless it has been set before.
+func (c *Config) WithTLSVersions(min, max uint16) *Config {
+	cfg := c.tlsClientConfig()
+	if min == 0 {
+		min = cfg.MinVersion
+	}
+	if max == 0 {
+		max = cfg.MaxVersion
+	}
+	if min != 0 && max != 0 && min > max {
+		c.configErr = multierror.Append(c.configErr, fmt.Errorf("Minimum TLS version %s is above the maximum %s", tls.VersionName(min), tls.VersionName(max)))
+		return c
+	}
+	cfg.MinVersion = min
+	cfg.MaxVersion = max
+	return c
+}
+
+// Limit the cipher suites offered for TLS 1.2 and earlier. The TLS 1.3 cipher suites are not configurable.
+func (c *Config) WithCipherSuites(ids ...uint16) *Config {
+	c.tlsClientConfig().CipherSuites = ids
+	return c
+}
+
+// Set the elliptic curves offered for key exchange, in order of preference.
+func (c *Config) WithCurvePreferences(curves ...tls.CurveID) *Config {
+	c.tlsClientConfig().CurvePreferences = curves
+	return c
+}
+
+// Send the server name given in the TLS handshake (SNI) rather than the host of the endpoint.
+// The ReST service's certificate is verified against this name.
+func (c *Config) WithServerName(name string) *Config {
+	c.tlsClientConfig().ServerName = name
+	return c
+}
+
+// Offer the application protocols given using ALPN, in order of preference.
+func (c *Config) WithALPNProtocols(protocols ...string) *Config {
+	c.tlsClientConfig().NextProtos = protocols
+	return c
+}
+
+// Resume TLS sessions with the ReST service, keeping up to the number of sessions given in a cache.
+// If the number is zero a default size is used.
+func (c *Config) WithTLSSessionCache(capacity int) *Config {
+	c.tlsClientConfig().ClientSessionCache = tls.NewLRUClientSessionCache(capacity)
+	return c
+}
+
+// applyTLSSettings applies the TLS settings loaded from a JSON file.
+func (c *Config) applyTLSSettings(s *TLSSettings) {
+	var min, max uint16
+	var err error
+	if min, err = parseTLSVersion(s.MinVersion); err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
+	}
+	if max, err = parseTLSVersion(s.MaxVersion); err != nil {
+		c.configErr = multierror.Append(c.configErr, err)
+	}
+	if min != 0 || max != 0 {
+		c.WithTLSVersions(min, max)
+	}
+	if len(s.CipherSuites) > 0 {
+		var ids []uint16
+		for _, name := range s.CipherSuites {
+			id, err := parseCipherSuite(name)
+			if err != nil {
+				c.configErr = multierror.Append(c.configErr, err)
+				continue
+			}
+			ids = append(ids, id)
+		}
+		c.WithCipherSuites(ids...)
+	}
+	if len(s.CurvePreferences) > 0 {
+		var curves []tls.CurveID
+		for _, name := range s.CurvePreferences {
+			curve, ok := tlsCurves[strings.TrimPrefix(strings.ToUpper(name), "CURVE")]
+			if !ok {
+				c.configErr = multierror.Append(c.configErr, fmt.Errorf("Unknown TLS curve %q", name))
+				continue
+			}
+			curves = append(curves, curve)
+		}
+		c.WithCurvePreferences(curves...)
+	}
+	if s.ServerName != "" {
+		c.WithServerName(s.ServerName)
+	}
+	if len(s.ALPNProtocols) > 0 {
+		c.WithALPNProtocols(s.ALPNProtocols...)
+	}
+	if s.SessionCacheSize > 0 {
+		c.WithTLSSessionCache(s.SessionCacheSize)
+	}
+}
+
+// parseTLSVersion returns the TLS version for names such as "1.2" or "TLS 1.2", or zero if the name is empty.
+func parseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(name), "TLS"))]
+	if !ok {
+		return 0, fmt.Errorf("Unknown TLS version %q", name)
+	}
+	return v, nil
+}
+
+func parseCipherSuite(name string) (uint16, error) {
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if cs.Name == name {
+			return cs.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown TLS cipher suite %q", name)
+}
@@ -0,0 +1,112 @@
+package restclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTLSStateServer starts a TLS server, with a certificate issued by the CA, that responds with the details of the TLS connection.
+func newTLSStateServer(t *testing.T, ca *testCA) *httptest.Server {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Field": "%s %s %s %s %t"}`, tls.VersionName(r.TLS.Version), tls.CipherSuiteName(r.TLS.CipherSuite),
+			r.TLS.ServerName, r.TLS.NegotiatedProtocol, r.TLS.DidResume)
+	}))
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.serverCert(t)},
+		NextProtos:   []string{"http/1.1"},
+	}
+	s.StartTLS()
+	return s
+}
+
+func TestConfig_TLSSettings(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	s := newTLSStateServer(t, ca)
+	defer s.Close()
+
+	c := NewConfig().WithEndPoint(s.URL).
+		WithTLSVersions(tls.VersionTLS12, tls.VersionTLS12).
+		WithCipherSuites(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256).
+		WithCurvePreferences(tls.CurveP256).
+		WithServerName("example.com").
+		WithALPNProtocols("http/1.1").
+		WithTLSSessionCache(10).
+		WithCACert(ca.cert)
+	f, err := sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "TLS 1.2 TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 example.com http/1.1 false", f)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "TLS 1.2 TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 example.com http/1.1 true", f, "TLS session not resumed")
+	assert.Equal(t, []tls.CurveID{tls.CurveP256}, c.tlsClientConfig().CurvePreferences)
+
+	// Settings made after the CA certificate are added to the same TLS configuration
+	c = NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithTLSVersions(tls.VersionTLS13, 0)
+	f, err = sendField(c)
+	assert.Nil(t, err)
+	assert.Equal(t, "TLS 1.3 TLS_AES_128_GCM_SHA256  http/1.1 false", f)
+
+	// The certificate is verified against the server name given
+	c = NewConfig().WithEndPoint(s.URL).WithCACert(ca.cert).WithServerName("other.example.com")
+	_, err = sendField(c)
+	assert.NotNil(t, err, "Certificate should not be valid for the server name given")
+
+	c = NewConfig().WithTLSVersions(tls.VersionTLS13, tls.VersionTLS12)
+	assert.NotNil(t, c.configErr, "Minimum TLS version above the maximum did not create an error in the configuration")
+
+	// A zero version leaves the bound already set
+	c = NewConfig().WithTLSVersions(0, tls.VersionTLS12)
+	c.applyTLSSettings(&TLSSettings{MinVersion: "1.1"})
+	assert.Nil(t, c.configErr)
+	assert.Equal(t, uint16(tls.VersionTLS11), c.tlsClientConfig().MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS12), c.tlsClientConfig().MaxVersion, "Maximum TLS version reset by setting the minimum")
+	c.WithTLSVersions(tls.VersionTLS13, 0)
+	assert.NotNil(t, c.configErr, "Minimum TLS version above the maximum already set did not create an error in the configuration")
+	assert.Equal(t, uint16(tls.VersionTLS11), c.tlsClientConfig().MinVersion)
+}
+
+func TestConfig_Load_TLSSettings(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "restclient")
+	defer os.RemoveAll(dir)
+	testConfigFile := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(testConfigFile, []byte(`{
+		"EndPoint": "http://testurl",
+		"TLS": {
+			"MinVersion": "1.2",
+			"MaxVersion": "TLS 1.3",
+			"CipherSuites": ["TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"],
+			"CurvePreferences": ["X25519", "CurveP256"],
+			"ServerName": "rest.example.com",
+			"ALPNProtocols": ["h2", "http/1.1"],
+			"SessionCacheSize": 32
+		}
+	}`), 0600)
+	c := Load(testConfigFile)
+	assert.Nil(t, c.Validate())
+	cfg := c.tlsClientConfig()
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, cfg.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, cfg.CurvePreferences)
+	assert.Equal(t, "rest.example.com", cfg.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, cfg.NextProtos)
+	assert.NotNil(t, cfg.ClientSessionCache)
+
+	ioutil.WriteFile(testConfigFile, []byte(`{
+		"EndPoint": "http://testurl",
+		"TLS": {
+			"MinVersion": "1.4",
+			"CipherSuites": ["TLS_NOT_A_SUITE"],
+			"CurvePreferences": ["P192"]
+		}
+	}`), 0600)
+	c = Load(testConfigFile)
+	assert.NotNil(t, c.Validate(), "Unknown TLS settings should make the configuration invalid")
+}